@@ -10,4 +10,6 @@ environment =
     POW_PORT="__POW_PORT__",
     POW_BASE_URL="__POW_BASE_URL__",
     POW_NAKED_DOMAIN="__POW_NAKED_DOMAIN__",
-    POW_REDIS_ADDR="__POW_REDIS_ADDR__"
+    POW_REDIS_ADDR="__POW_REDIS_ADDR__",
+    POW_COOKIE_SECRET="__POW_COOKIE_SECRET__",
+    POW_ADMIN_TOKEN="__POW_ADMIN_TOKEN__"
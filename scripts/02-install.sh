@@ -20,6 +20,8 @@ POW_PORT=`ask.sh pow POW_PORT 'Which local port should the server listen on :'`
 POW_NAKED_DOMAIN=`ask.sh pow POW_NAKED_DOMAIN 'What is the naked domain (e.g. localhost:1234 or pow.gd) :'`
 POW_BASE_URL=`ask.sh pow POW_BASE_URL 'What is the base URL (e.g. http://localhost:1234 or https://pow.gd) :'`
 POW_REDIS_ADDR=`ask.sh pow POW_REDIS_ADDR 'Which Redis server should be used for hits (e.g. ":6379") :'`
+POW_COOKIE_SECRET=`ask.sh pow POW_COOKIE_SECRET 'What secret should be used to sign cookies (e.g. a long random string) :'`
+POW_ADMIN_TOKEN=`ask.sh pow POW_ADMIN_TOKEN 'What token should be used for the admin API (e.g. a long random string) :'`
 
 echo "Building code ..."
 gb build
@@ -32,6 +34,8 @@ m4 \
     -D __POW_NAKED_DOMAIN__=$POW_NAKED_DOMAIN \
     -D __POW_BASE_URL__=$POW_BASE_URL \
     -D __POW_REDIS_ADDR__=$POW_REDIS_ADDR \
+    -D __POW_COOKIE_SECRET__=$POW_COOKIE_SECRET \
+    -D __POW_ADMIN_TOKEN__=$POW_ADMIN_TOKEN \
     etc/supervisor/conf.d/gd-pow.conf.m4 | sudo tee /etc/supervisor/conf.d/gd-pow.conf
 echo
 
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gomiddleware/logger"
+	"github.com/gomiddleware/mux"
+)
+
+var (
+	ErrNotAdmin      = errors.New("A valid admin token is required")
+	ErrAdminDisabled = errors.New("Admin is disabled since no POW_ADMIN_TOKEN has been set")
+)
+
+// adminToken returns the admin token given with this request, either as a bearer token or as the password using basic
+// auth (which lets browsers prompt for it).
+func adminToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// isAdmin tells you whether this request has the admin token, which is never true if there isn't one.
+func isAdmin(r *http.Request, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(adminToken(r)), []byte(token)) == 1
+}
+
+// requireAdmin is middleware which only allows requests through which have the admin token. If no token is configured
+// then admin is disabled entirely.
+func requireAdmin(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				sendApiError(w, http.StatusForbidden, "admin-disabled", ErrAdminDisabled)
+				return
+			}
+
+			if !isAdmin(r, token) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pow admin"`)
+				sendApiError(w, http.StatusUnauthorized, "unauthorized", ErrNotAdmin)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func adminListTakedowns(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		takedowns, err := store.listTakedowns()
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		sendJson(w, http.StatusOK, takedowns)
+	}
+}
+
+func adminCreateTakedown(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		takedown := Takedown{}
+		if err := json.NewDecoder(r.Body).Decode(&takedown); err != nil {
+			sendApiError(w, http.StatusBadRequest, "invalid-body", ErrUnreadableApi)
+			return
+		}
+		if takedown.Status == 0 {
+			takedown.Status = http.StatusGone
+		}
+		takedown.Created = now()
+
+		err := store.putTakedown(&takedown)
+		if err == ErrInvalidTakedown {
+			sendApiError(w, http.StatusBadRequest, "invalid-takedown", err)
+			return
+		}
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("ShortUrlId", takedown.Id)
+		lgr.WithField("Status", strconv.Itoa(takedown.Status))
+		lgr.Print("short-url-taken-down")
+
+		sendJson(w, http.StatusCreated, takedown)
+	}
+}
+
+func adminDeleteTakedown(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := store.delTakedown(mux.Vals(r)["id"])
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func adminListBlocks(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		blocks, err := store.listBlocks()
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		sendJson(w, http.StatusOK, blocks)
+	}
+}
+
+func adminCreateBlock(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		block := Block{}
+		if err := json.NewDecoder(r.Body).Decode(&block); err != nil {
+			sendApiError(w, http.StatusBadRequest, "invalid-body", ErrUnreadableApi)
+			return
+		}
+		block.Created = now()
+
+		err := store.putBlock(&block)
+		if err == ErrInvalidBlock {
+			sendApiError(w, http.StatusBadRequest, "invalid-block", err)
+			return
+		}
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("Pattern", block.Pattern)
+		lgr.Print("destination-blocked")
+
+		sendJson(w, http.StatusCreated, block)
+	}
+}
+
+// adminDeleteBlock takes the pattern from the query string since patterns may contain slashes.
+func adminDeleteBlock(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.FormValue("pattern")
+		if pattern == "" {
+			sendApiError(w, http.StatusBadRequest, "invalid-block", ErrInvalidBlock)
+			return
+		}
+
+		err := store.delBlock(pattern)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gomiddleware/mux"
+)
+
+const apiDefaultLimit = 50
+const apiMaxLimit = 500
+
+var (
+	ErrUrlNotFound   = errors.New("Short URL not found")
+	ErrInvalidLimit  = errors.New("Limit must be a number between 1 and 500")
+	ErrUnreadableApi = errors.New("Request body must be valid JSON")
+	ErrNoQuery       = errors.New("Either q or tag is required")
+)
+
+// decodeApiBody fills v from either a JSON body or the regular form values, depending on the Content-Type.
+func decodeApiBody(r *http.Request, v *ApiNewUrl) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			return ErrUnreadableApi
+		}
+		return nil
+	}
+
+	in, err := newUrlFromForm(r)
+	*v = in
+	return err
+}
+
+// newApiUrl returns what the API shows for this ShortUrl. The password and owner hashes are never shown and neither
+// are the destinations (including any rules) of a password protected ShortUrl.
+func newApiUrl(shortUrl ShortUrl, stats *Stats, baseUrl string) *ApiUrl {
+	apiUrl := ApiUrl{
+		ShortUrl: shortUrl,
+		Link:     baseUrl + "/" + shortUrl.Id,
+		Stats:    stats,
+	}
+	apiUrl.Owner = ""
+
+	if apiUrl.Password != "" {
+		apiUrl.Protected = true
+		apiUrl.ShortUrl = apiUrl.ShortUrl.redacted()
+	}
+
+	return &apiUrl
+}
+
+func apiCreateUrl(store Store, lists *blocklists, ids idGenerator, baseUrl string, appSchemes []string, fetcher *metaFetcher, proof *hashcash) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hold, err := proof.require(r, now())
+		if err != nil {
+			sendApiError(w, http.StatusForbidden, proofErrorCode(err), err)
+			return
+		}
+		defer hold.release()
+
+		newUrl := ApiNewUrl{}
+		if err := decodeApiBody(r, &newUrl); err != nil {
+			if fieldErr, ok := err.(*FieldError); ok {
+				sendApiError(w, http.StatusBadRequest, "invalid-"+fieldErr.Field, err)
+				return
+			}
+			sendApiError(w, http.StatusBadRequest, "invalid-body", err)
+			return
+		}
+
+		shortUrl, err := newShortUrl(newUrl, appSchemes, now())
+		if fieldErr, ok := err.(*FieldError); ok {
+			sendApiError(w, http.StatusBadRequest, "invalid-"+fieldErr.Field, err)
+			return
+		}
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		// shared ShortUrls don't belong to anyone
+		reuse := wantsReuse(newUrl, shortUrl)
+		token := ""
+		if !reuse {
+			token, err = newOwnerToken(shortUrl)
+			if err != nil {
+				sendApiError(w, http.StatusInternalServerError, "internal", err)
+				return
+			}
+		}
+
+		reused, err := store.createShortUrl(lists, ids, shortUrl, newUrl.Slug, reuse)
+		if err == ErrSlugTaken {
+			sendApiError(w, http.StatusConflict, "slug-taken", err)
+			return
+		}
+		if err == ErrDestinationBlocked {
+			sendApiError(w, http.StatusBadRequest, "blocked-url", err)
+			return
+		}
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		hold.spend(now())
+		w.Header().Set("Location", baseUrl+"/api/v1/urls/"+shortUrl.Id)
+
+		// an existing ShortUrl for this destination, which this caller can't manage
+		if reused {
+			stats, err := store.getStats(shortUrl.Id)
+			if err != nil {
+				sendApiError(w, http.StatusInternalServerError, "internal", err)
+				return
+			}
+			sendJson(w, http.StatusOK, newApiUrl(*shortUrl, stats, baseUrl))
+			return
+		}
+
+		fetcher.enqueue(shortUrl.Id)
+
+		apiUrl := newApiUrl(*shortUrl, &Stats{}, baseUrl)
+		if token != "" {
+			apiUrl.Token = token
+			apiUrl.ManageLink = manageLink(baseUrl, shortUrl.Id, token)
+		}
+		sendJson(w, http.StatusCreated, apiUrl)
+	}
+}
+
+func apiGetUrl(store Store, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+
+		// taken down ShortUrls say why, just like when they are visited, even if the ShortUrl itself is gone
+		takedown, err := store.getTakedown(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if takedown != nil {
+			sendApiError(w, takedown.Status, "taken-down", errors.New(takedown.Reason))
+			return
+		}
+		tombstone, err := store.getTombstone(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if tombstone != nil {
+			sendApiError(w, http.StatusGone, "gone", errors.New(tombstone.Reason))
+			return
+		}
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if shortUrl == nil {
+			sendApiError(w, http.StatusNotFound, "not-found", ErrUrlNotFound)
+			return
+		}
+
+		stats, err := store.getStats(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		sendJson(w, http.StatusOK, newApiUrl(*shortUrl, stats, baseUrl))
+	}
+}
+
+func apiListUrls(store Store, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := apiDefaultLimit
+		if str := r.FormValue("limit"); str != "" {
+			n, err := strconv.Atoi(str)
+			if err != nil || n < 1 || n > apiMaxLimit {
+				sendApiError(w, http.StatusBadRequest, "invalid-limit", ErrInvalidLimit)
+				return
+			}
+			limit = n
+		}
+
+		urls, next, err := store.listShortUrls(r.FormValue("cursor"), limit)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		// taken down ShortUrls are left out, so a page may have fewer than limit even when there are more to come
+		listed := make([]*ApiUrl, 0, len(urls))
+		for _, apiUrl := range urls {
+			takedown, err := store.getTakedown(apiUrl.Id)
+			if err != nil {
+				sendApiError(w, http.StatusInternalServerError, "internal", err)
+				return
+			}
+			if takedown == nil {
+				listed = append(listed, newApiUrl(apiUrl.ShortUrl, apiUrl.Stats, baseUrl))
+			}
+		}
+
+		sendJson(w, http.StatusOK, ApiUrlList{Urls: listed, Next: next})
+	}
+}
+
+func apiSearch(store Store, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.FormValue("q"))
+		tag := strings.ToLower(strings.TrimSpace(r.FormValue("tag")))
+		if q == "" && tag == "" {
+			sendApiError(w, http.StatusBadRequest, "invalid-query", ErrNoQuery)
+			return
+		}
+
+		results, err := store.searchShortUrls(q, tag)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		urls := make([]*ApiUrl, 0, len(results))
+		for _, shortUrl := range results {
+			stats, err := store.getStats(shortUrl.Id)
+			if err != nil {
+				sendApiError(w, http.StatusInternalServerError, "internal", err)
+				return
+			}
+			urls = append(urls, newApiUrl(*shortUrl, stats, baseUrl))
+		}
+
+		sendJson(w, http.StatusOK, ApiSearch{Query: q, Tag: tag, Urls: urls})
+	}
+}
+
+func apiListTags(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tags, err := store.listTags()
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		sendJson(w, http.StatusOK, ApiTagList{Tags: tags})
+	}
+}
+
+func apiUpdateUrl(store Store, lists *blocklists, baseUrl string, appSchemes []string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+
+		update := ApiUpdateUrl{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			sendApiError(w, http.StatusBadRequest, "invalid-body", ErrUnreadableApi)
+			return
+		}
+		if update.Url == nil && update.Disabled == nil && update.RedirectStatus == nil && update.QueryPassthrough == nil &&
+			update.Title == nil && update.Notes == nil && update.Tags == nil && update.PathPassthrough == nil && update.Campaign == nil && update.Rules == nil &&
+			update.Variants == nil && update.Sticky == nil && update.DeepLink == nil &&
+			update.Template == nil {
+			sendApiError(w, http.StatusBadRequest, "invalid-body", ErrNothingChanged)
+			return
+		}
+
+		shortUrl, err := store.updateShortUrl(lists, id, func(shortUrl *ShortUrl) error {
+			if !isOwner(shortUrl, bearerToken(r)) {
+				return ErrNotOwner
+			}
+
+			t := now()
+			if update.Url != nil {
+				if err := changeDestination(shortUrl, *update.Url, t); err != nil {
+					return err
+				}
+			}
+			if update.Disabled != nil {
+				shortUrl.Disabled = *update.Disabled
+			}
+			if update.Title != nil {
+				title, err := validateTitle(*update.Title)
+				if err != nil {
+					return &FieldError{"title", err}
+				}
+				shortUrl.Title = title
+			}
+			if update.Notes != nil {
+				if len(*update.Notes) > notesMaxLen {
+					return &FieldError{"notes", ErrNotesTooLong}
+				}
+				shortUrl.Notes = *update.Notes
+			}
+			if update.Tags != nil {
+				tags, err := validateTags(*update.Tags)
+				if err != nil {
+					return &FieldError{"tags", err}
+				}
+				shortUrl.Tags = tags
+			}
+			if update.RedirectStatus != nil {
+				if *update.RedirectStatus != 0 && !isRedirectStatus(*update.RedirectStatus) {
+					return &FieldError{"redirect-status", ErrInvalidRedirectStatus}
+				}
+				shortUrl.RedirectStatus = *update.RedirectStatus
+			}
+			if update.QueryPassthrough != nil {
+				if !isQueryPassthrough(*update.QueryPassthrough) {
+					return &FieldError{"query-passthrough", ErrInvalidQueryPassthrough}
+				}
+				shortUrl.QueryPassthrough = *update.QueryPassthrough
+			}
+			if update.PathPassthrough != nil {
+				shortUrl.PathPassthrough = *update.PathPassthrough
+			}
+			if update.Campaign != nil {
+				campaign, err := validateCampaign(update.Campaign)
+				if err != nil {
+					return &FieldError{"campaign", err}
+				}
+				shortUrl.Campaign = campaign
+			}
+			if update.Rules != nil {
+				rules, err := validateRules(*update.Rules)
+				if err != nil {
+					return &FieldError{"rules", err}
+				}
+				shortUrl.Rules = rules
+			}
+			if update.Variants != nil {
+				variants, err := validateVariants(*update.Variants)
+				if err != nil {
+					return &FieldError{"variants", err}
+				}
+				shortUrl.Variants = variants
+			}
+			if update.Sticky != nil {
+				shortUrl.Sticky = *update.Sticky
+			}
+			if update.DeepLink != nil {
+				deepLink, err := validateDeepLink(update.DeepLink, appSchemes)
+				if err != nil {
+					return &FieldError{"deep-link", err}
+				}
+				shortUrl.DeepLink = deepLink
+			}
+			if update.Template != nil {
+				template, err := validateTemplate(*update.Template)
+				if err != nil {
+					return &FieldError{"template", err}
+				}
+				shortUrl.Template = template
+			}
+			shortUrl.Updated = t
+			return nil
+		})
+		if err == ErrNotOwner {
+			sendApiError(w, http.StatusForbidden, "forbidden", err)
+			return
+		}
+		if err == ErrDestinationBlocked {
+			sendApiError(w, http.StatusBadRequest, "blocked-url", err)
+			return
+		}
+		if fieldErr, ok := err.(*FieldError); ok {
+			sendApiError(w, http.StatusBadRequest, "invalid-"+fieldErr.Field, err)
+			return
+		}
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if shortUrl == nil {
+			sendApiError(w, http.StatusNotFound, "not-found", ErrUrlNotFound)
+			return
+		}
+
+		stats, err := store.getStats(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		sendJson(w, http.StatusOK, newApiUrl(*shortUrl, stats, baseUrl))
+	}
+}
+
+func apiDeleteUrl(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if shortUrl == nil {
+			sendApiError(w, http.StatusNotFound, "not-found", ErrUrlNotFound)
+			return
+		}
+		if !isOwner(shortUrl, bearerToken(r)) {
+			sendApiError(w, http.StatusForbidden, "forbidden", ErrNotOwner)
+			return
+		}
+
+		err = store.deleteShortUrl(id, now())
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gomiddleware/logger"
+	"github.com/gomiddleware/logit"
+	"github.com/gomiddleware/mux"
+)
+
+// newTestMux returns a new mux with the request logger, which handlers use, discarding it's output.
+func newTestMux() *mux.Mux {
+	m := mux.New()
+	m.Use("/", logger.NewLogger(logit.New(io.Discard, "pow")))
+	return m
+}
+
+// newTestApi returns the API routes just like they are set up in main(), using a new store.
+func newTestApi(t *testing.T) *mux.Mux {
+	return newTestApiFor(newTestStore(t))
+}
+
+// testAdminToken is the admin token of the test API, which can list every ShortUrl.
+const testAdminToken = "test-admin"
+
+// newTestApiFor returns the API routes using this store.
+func newTestApiFor(store Store) *mux.Mux {
+	m := newTestMux()
+	m.Post("/api/v1/urls", apiCreateUrl(store, noLists, testIds, "https://pow.example", []string{"myapp"}, newMetaFetcher(store, nil), nil))
+	m.Get("/api/v1/urls", requireApiKey(apiKeys{"test-key"}, testAdminToken), apiListUrls(store, "https://pow.example"))
+	m.Get("/api/v1/urls/:id", apiGetUrl(store, "https://pow.example"))
+	m.Patch("/api/v1/urls/:id", apiUpdateUrl(store, noLists, "https://pow.example", []string{"myapp"}))
+	m.Delete("/api/v1/urls/:id", apiDeleteUrl(store))
+	return m
+}
+
+// apiRequest sends this request to m and decodes any JSON response into v.
+func apiRequest(t *testing.T, m http.Handler, method, path, body string, v interface{}) *httptest.ResponseRecorder {
+	return apiRequestAs(t, m, "", method, path, body, v)
+}
+
+// apiRequestAs is the same as apiRequest() but sends this token as the bearer token, if given.
+func apiRequestAs(t *testing.T, m http.Handler, token, method, path, body string, v interface{}) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %s in %q", method, path, err, rec.Body.String())
+		}
+	}
+	return rec
+}
+
+func TestApiCreateAndGet(t *testing.T) {
+	m := newTestApi(t)
+
+	created := ApiUrl{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/docs"}`, &created)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+	if created.Id == "" || created.Url != "https://example.com/docs" || created.Link != "https://pow.example/"+created.Id {
+		t.Errorf("create: unexpected %+v", created)
+	}
+	if loc := rec.Header().Get("Location"); loc != "https://pow.example/api/v1/urls/"+created.Id {
+		t.Errorf("create: Location is %q", loc)
+	}
+
+	got := ApiUrl{}
+	rec = apiRequest(t, m, "GET", "/api/v1/urls/"+created.Id, "", &got)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got.Id != created.Id || got.Url != created.Url || got.Stats == nil {
+		t.Errorf("get: got %+v, want %+v", got, created)
+	}
+}
+
+func TestApiErrors(t *testing.T) {
+	m := newTestApi(t)
+
+	tests := []struct {
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"", "POST", "/api/v1/urls", `{"Url":"ftp://example.com/"}`, http.StatusBadRequest, "invalid-url"},
+		{"", "POST", "/api/v1/urls", `{"Url":`, http.StatusBadRequest, "invalid-body"},
+		{"", "GET", "/api/v1/urls/nope", "", http.StatusNotFound, "not-found"},
+		{"", "GET", "/api/v1/urls", "", http.StatusForbidden, "key-required"},
+		{"wrong", "GET", "/api/v1/urls", "", http.StatusForbidden, "key-required"},
+		{testAdminToken, "GET", "/api/v1/urls?limit=0", "", http.StatusBadRequest, "invalid-limit"},
+		{testAdminToken, "GET", "/api/v1/urls?limit=501", "", http.StatusBadRequest, "invalid-limit"},
+	}
+	for _, test := range tests {
+		apiErr := ApiError{}
+		rec := apiRequestAs(t, m, test.token, test.method, test.path, test.body, &apiErr)
+		if rec.Code != test.status || apiErr.Status != test.status || apiErr.Code != test.code {
+			t.Errorf("%s %s: got %d %+v, want %d %s", test.method, test.path, rec.Code, apiErr, test.status, test.code)
+		}
+	}
+}
+
+func TestApiList(t *testing.T) {
+	m := newTestApi(t)
+	for i := 0; i < 3; i++ {
+		apiRequest(t, m, "POST", "/api/v1/urls", fmt.Sprintf(`{"Url":"https://example.com/%d"}`, i), nil)
+	}
+
+	first := ApiUrlList{}
+	apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls?limit=2", "", &first)
+	if len(first.Urls) != 2 || first.Next == "" {
+		t.Fatalf("first page: got %d urls and next %q", len(first.Urls), first.Next)
+	}
+	if first.Urls[0].Link == "" {
+		t.Error("first page: no Link")
+	}
+
+	second := ApiUrlList{}
+	apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls?limit=2&cursor="+first.Next, "", &second)
+	if len(second.Urls) != 1 || second.Next != "" {
+		t.Errorf("second page: got %d urls and next %q", len(second.Urls), second.Next)
+	}
+
+	// an API key is just as good as the admin token
+	req := httptest.NewRequest("GET", "/api/v1/urls", nil)
+	req.Header.Set("X-Api-Key", "test-key")
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("with an API key: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var ErrApiKeyRequired = errors.New("An API key or the admin token is required")
+
+// apiKeys are the keys given in POW_API_KEYS, which let trusted clients skip the checks made of anonymous ones. They
+// are sent in the X-Api-Key header.
+type apiKeys []string
+
+func parseApiKeys(str string) apiKeys {
+	keys := make(apiKeys, 0)
+	for _, key := range strings.Split(str, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// find returns the API key given with this request, or "" if it doesn't have a valid one.
+func (keys apiKeys) find(r *http.Request) string {
+	return keys.match(r.Header.Get("X-Api-Key"))
+}
+
+// match returns the API key given, or "" if it isn't one of these.
+func (keys apiKeys) match(given string) string {
+	if given == "" {
+		return ""
+	}
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1 {
+			return key
+		}
+	}
+	return ""
+}
+
+// requireApiKey is middleware which only allows requests through which have one of these API keys or the admin token,
+// for things which shouldn't be open to anyone such as listing every ShortUrl.
+func requireApiKey(keys apiKeys, token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if keys.find(r) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if isAdmin(r, token) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sendApiError(w, http.StatusForbidden, "key-required", ErrApiKeyRequired)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// names which hosts files map to themselves, rather than block
+var hostsFileNames = []string{"localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback"}
+
+// blocklist is one file of malware and phishing destinations. Each line is one of:
+//
+//	0.0.0.0 evil.com other.com   - a hosts file entry, blocking these domains
+//	evil.com                     - a domain, which also blocks all of it's subdomains
+//	evil.com/phish/*             - a pattern (with a `*` and/or `/`), just like a Block
+//
+// Blank lines and anything after a `#` are ignored, as is any http:// or https:// in front of a domain or pattern.
+type blocklist struct {
+	name     string
+	domains  map[string]bool
+	patterns []string
+}
+
+// parseLine adds whatever this line blocks to the list.
+func (l *blocklist) parseLine(line string) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(strings.ToLower(line))
+	if len(fields) == 0 {
+		return
+	}
+
+	// a hosts file entry
+	if net.ParseIP(fields[0]) != nil {
+		for _, domain := range fields[1:] {
+			if strings.Contains(domain, ".") && net.ParseIP(domain) == nil && !containsString(hostsFileNames, domain) {
+				l.domains[domain] = true
+			}
+		}
+		return
+	}
+
+	entry := strings.TrimPrefix(strings.TrimPrefix(fields[0], "http://"), "https://")
+	if strings.ContainsAny(entry, "*/") {
+		l.patterns = append(l.patterns, entry)
+	} else if entry != "" {
+		l.domains[entry] = true
+	}
+}
+
+// matches tells you whether this list blocks this destination.
+func (l *blocklist) matches(u *url.URL) bool {
+	// the host and each of it's parent domains
+	host := strings.ToLower(u.Hostname())
+	for host != "" {
+		if l.domains[host] {
+			return true
+		}
+		i := strings.Index(host, ".")
+		if i == -1 {
+			break
+		}
+		host = host[i+1:]
+	}
+
+	for _, pattern := range l.patterns {
+		if matchesPattern(pattern, u) {
+			return true
+		}
+	}
+	return false
+}
+
+func readBlocklist(path string) (*blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := blocklist{
+		name:    filepath.Base(path),
+		domains: make(map[string]bool),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l.parseLine(scanner.Text())
+	}
+	return &l, scanner.Err()
+}
+
+// blocklists are the local blocklist files given in POW_BLOCKLISTS, which are checked for every new destination and
+// again on every redirect. Unlike Blocks, these are never saved and can be reloaded at any time.
+type blocklists struct {
+	paths []string
+
+	mu    sync.RWMutex
+	lists []*blocklist
+}
+
+// newBlocklists returns the blocklists for this comma separated list of files, which still need to be loaded.
+func newBlocklists(str string) *blocklists {
+	paths := make([]string, 0)
+	for _, path := range strings.Split(str, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return &blocklists{paths: paths}
+}
+
+// load (re)reads every file. If any of them can't be read then the lists already loaded are kept.
+func (b *blocklists) load() error {
+	lists := make([]*blocklist, 0, len(b.paths))
+	for _, path := range b.paths {
+		l, err := readBlocklist(path)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, l)
+	}
+
+	b.mu.Lock()
+	b.lists = lists
+	b.mu.Unlock()
+	return nil
+}
+
+// reloader reloads the lists whenever we get a SIGHUP, and also every interval (if not 0).
+func (b *blocklists) reloader(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+		}
+		if err := b.load(); err != nil {
+			log.Printf("blocklist: %s\n", err)
+			continue
+		}
+		log.Printf("blocklist: reloaded %d lists\n", len(b.paths))
+	}
+}
+
+// match returns the name of the first list which blocks this destination, or "" if none do.
+func (b *blocklists) match(str string) string {
+	u, err := url.Parse(str)
+	if err != nil {
+		return ""
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, l := range b.lists {
+		if l.matches(u) {
+			return l.name
+		}
+	}
+	return ""
+}
+
+// check returns ErrDestinationBlocked if any list blocks this destination, logging which one did.
+func (b *blocklists) check(str string) error {
+	if name := b.match(str); name != "" {
+		log.Printf("blocklist: %s is on %s\n", str, name)
+		return ErrDestinationBlocked
+	}
+	return nil
+}
+
+// warnListed shows the warning given instead of redirecting to a destination which is on a blocklist.
+func warnListed(w http.ResponseWriter, tmpl *template.Template, dest string) {
+	data := struct {
+		Destination string
+	}{
+		dest,
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	renderStatus(w, tmpl, http.StatusForbidden, "listed.html", data)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeBlocklist writes a blocklist file into this test's temporary directory and returns it's path.
+func writeBlocklist(t *testing.T, name, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBlocklistMatches(t *testing.T) {
+	l := blocklist{domains: make(map[string]bool)}
+	for _, line := range strings.Split(`# a hosts file
+0.0.0.0 malware.example tracker.example # trailing comment
+127.0.0.1 localhost
+::1 ip6-localhost
+https://Phish.Example
+evil.example/login/*
+`, "\n") {
+		l.parseLine(line)
+	}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://malware.example/", true},
+		{"http://cdn.tracker.example/x.js", true},
+		{"https://phish.example/anything", true},
+		{"https://www.PHISH.example/", true},
+		{"https://notphish.example/", false},
+		{"https://evil.example/login/now", true},
+		{"https://evil.example/about", false},
+		{"http://localhost/", false},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := l.matches(u); got != test.want {
+			t.Errorf("matches(%s) = %v, want %v", test.url, got, test.want)
+		}
+	}
+}
+
+func TestBlocklists(t *testing.T) {
+	malware := writeBlocklist(t, "malware.txt", "0.0.0.0 malware.example\n")
+	phishing := writeBlocklist(t, "phishing.txt", "phish.example\n")
+
+	lists := newBlocklists(" " + malware + ",," + phishing + " ")
+	if err := lists.load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := lists.match("https://www.phish.example/"); got != "phishing.txt" {
+		t.Errorf("got %q, want phishing.txt", got)
+	}
+	if err := lists.check("https://malware.example/"); err != ErrDestinationBlocked {
+		t.Errorf("got %v, want ErrDestinationBlocked", err)
+	}
+	if err := lists.check("https://example.com/"); err != nil {
+		t.Errorf("got %v", err)
+	}
+
+	// if a list goes missing, the ones already loaded are kept
+	if err := os.Remove(phishing); err != nil {
+		t.Fatal(err)
+	}
+	if err := lists.load(); err == nil {
+		t.Error("load: got no error for a missing list")
+	}
+	if got := lists.match("https://phish.example/"); got != "phishing.txt" {
+		t.Errorf("after a failed reload: got %q", got)
+	}
+
+	// and nothing can be created or changed to go there
+	store := newTestStore(t)
+	if _, err := store.createShortUrl(lists, testIds, &ShortUrl{Url: "https://phish.example/"}, "", false); err != ErrDestinationBlocked {
+		t.Errorf("create: got %v, want ErrDestinationBlocked", err)
+	}
+	variant := ShortUrl{Url: "https://example.com/", Variants: []Variant{{Name: "A", Url: "https://malware.example/", Weight: 1}}}
+	if _, err := store.createShortUrl(lists, testIds, &variant, "", false); err != ErrDestinationBlocked {
+		t.Errorf("create with a variant: got %v, want ErrDestinationBlocked", err)
+	}
+	shortUrl := ShortUrl{Url: "https://example.com/"}
+	if _, err := store.createShortUrl(lists, testIds, &shortUrl, "", false); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.updateShortUrl(lists, shortUrl.Id, func(shortUrl *ShortUrl) error {
+		return changeDestination(shortUrl, "https://malware.example/", now())
+	})
+	if err != ErrDestinationBlocked {
+		t.Errorf("update: got %v, want ErrDestinationBlocked", err)
+	}
+}
+
+func TestWarnListed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	warnListed(rec, newTestTemplates(t), "https://phish.example/")
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), "https://phish.example/") {
+		t.Errorf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
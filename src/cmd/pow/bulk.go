@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gomiddleware/logger"
+)
+
+const bulkMaxBytes = 5 * 1024 * 1024
+const bulkMaxRows = 5000
+const bulkBatchSize = 100
+
+const bulkCsv = "csv"
+const bulkJsonl = "jsonl"
+
+var (
+	ErrBulkFormat   = errors.New("Upload must be either CSV (text/csv) or JSON Lines (application/x-ndjson)")
+	ErrBulkTooMany  = errors.New("Upload must contain at most 5000 rows")
+	ErrBulkNoRows   = errors.New("Upload must contain at least one row")
+	ErrBulkNoFile   = errors.New("Please choose a file to upload")
+	ErrBulkBadRow   = errors.New("Row must be a JSON object such as {\"Url\":\"https://...\"}")
+	ErrBulkInternal = errors.New("Internal error, please try this row again")
+	ErrBulkNoKey    = errors.New("Bulk shortening needs one of the API keys in POW_API_KEYS")
+)
+
+// bulkRow is one row read from an upload, along with any error from reading it.
+type bulkRow struct {
+	in  ApiNewUrl
+	err error
+}
+
+// bulkFormat decides whether this is a CSV or JSONL upload from it's content type, or failing that it's filename.
+func bulkFormat(contentType, filename string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return bulkCsv, nil
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return bulkJsonl, nil
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return bulkCsv, nil
+	case ".jsonl", ".ndjson":
+		return bulkJsonl, nil
+	}
+
+	return "", ErrBulkFormat
+}
+
+// readBulk reads all rows from this upload. CSV rows are "url,slug,notes" where slug and notes are optional, and an
+// initial header row starting with "url" is skipped. JSONL rows are objects just like ApiNewUrl.
+func readBulk(format string, r io.Reader) ([]bulkRow, error) {
+	rows := make([]bulkRow, 0)
+
+	if format == bulkCsv {
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		cr.TrimLeadingSpace = true
+		for {
+			record, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) == 0 && len(record) > 0 && strings.EqualFold(record[0], "url") {
+				continue
+			}
+
+			row := bulkRow{}
+			for i, field := range record {
+				switch i {
+				case 0:
+					row.in.Url = field
+				case 1:
+					row.in.Slug = field
+				case 2:
+					row.in.Notes = field
+				}
+			}
+			rows = append(rows, row)
+			if len(rows) > bulkMaxRows {
+				return nil, ErrBulkTooMany
+			}
+		}
+	} else {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			row := bulkRow{}
+			if err := json.Unmarshal(line, &row.in); err != nil {
+				row.err = ErrBulkBadRow
+			}
+			rows = append(rows, row)
+			if len(rows) > bulkMaxRows {
+				return nil, ErrBulkTooMany
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrBulkNoRows
+	}
+	return rows, nil
+}
+
+// createBulk validates and creates a ShortUrl for each row, using one transaction per batch of rows. Every row gets a
+// result, whether it was successful or not. The metadata of each new ShortUrl is then fetched in the background.
+func createBulk(store Store, lists *blocklists, ids idGenerator, rows []bulkRow, baseUrl string, appSchemes []string, fetcher *metaFetcher) []BulkResult {
+	results := make([]BulkResult, len(rows))
+	shortUrls := make([]*ShortUrl, len(rows))
+	t := now()
+
+	// validate everything first, outside of any transaction
+	for i, row := range rows {
+		results[i] = BulkResult{
+			Row:   i + 1,
+			Url:   row.in.Url,
+			Slug:  row.in.Slug,
+			Notes: row.in.Notes,
+		}
+		if row.err != nil {
+			results[i].Error = row.err.Error()
+			continue
+		}
+
+		shortUrl, err := newShortUrl(row.in, appSchemes, t)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		// shared ShortUrls don't belong to anyone
+		if !wantsReuse(row.in, shortUrl) {
+			token, err := newOwnerToken(shortUrl)
+			if err != nil {
+				results[i].Error = ErrBulkInternal.Error()
+				continue
+			}
+			results[i].Token = token
+		}
+		shortUrls[i] = shortUrl
+	}
+
+	for start := 0; start < len(rows); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		// the rows of this batch which are valid, and what's to be created for each
+		batch := make([]int, 0, end-start)
+		pending := make([]*pendingShortUrl, 0, end-start)
+		for i := start; i < end; i++ {
+			if shortUrls[i] == nil {
+				continue
+			}
+			batch = append(batch, i)
+			pending = append(pending, &pendingShortUrl{
+				shortUrl: shortUrls[i],
+				slug:     rows[i].in.Slug,
+				reuse:    wantsReuse(rows[i].in, shortUrls[i]),
+			})
+		}
+
+		// the whole batch was rolled back, so none of these rows were created
+		if err := store.createShortUrls(lists, ids, pending); err != nil {
+			for i := start; i < end; i++ {
+				if shortUrls[i] != nil && results[i].Error == "" {
+					results[i].Link = ""
+					results[i].Token = ""
+					results[i].Error = ErrBulkInternal.Error()
+				}
+			}
+			continue
+		}
+
+		for j, p := range pending {
+			i := batch[j]
+			if p.err != nil {
+				results[i].Error = p.err.Error()
+				results[i].Token = ""
+				continue
+			}
+			results[i].Link = baseUrl + "/" + p.shortUrl.Id
+			if p.reused {
+				results[i].Token = ""
+			} else {
+				fetcher.enqueue(p.shortUrl.Id)
+			}
+		}
+	}
+
+	return results
+}
+
+// writeBulk writes the results in the same format as the upload.
+func writeBulk(format string, w io.Writer, results []BulkResult) error {
+	if format == bulkCsv {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"row", "url", "slug", "notes", "link", "token", "error"})
+		for _, res := range results {
+			cw.Write([]string{strconv.Itoa(res.Row), res.Url, res.Slug, res.Notes, res.Link, res.Token, res.Error})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	enc := json.NewEncoder(w)
+	for _, res := range results {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bulkContentType(format string) string {
+	if format == bulkCsv {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// countBulkErrors returns how many rows failed, for logging.
+func countBulkErrors(results []BulkResult) int {
+	n := 0
+	for _, res := range results {
+		if res.Error != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// apiBulk takes a CSV or JSONL body and returns the results in the same format. Since one request can create thousands
+// of ShortUrls, it needs an API key rather than a proof of work or a share of the anonymous rate limit.
+func apiBulk(store Store, lists *blocklists, ids idGenerator, keys apiKeys, baseUrl string, appSchemes []string, fetcher *metaFetcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if keys.find(r) == "" {
+			sendApiError(w, http.StatusForbidden, "key-required", ErrBulkNoKey)
+			return
+		}
+
+		format, err := bulkFormat(r.Header.Get("Content-Type"), "")
+		if err != nil {
+			sendApiError(w, http.StatusUnsupportedMediaType, "invalid-format", err)
+			return
+		}
+
+		rows, err := readBulk(format, http.MaxBytesReader(w, r.Body, bulkMaxBytes))
+		if err != nil {
+			sendApiError(w, http.StatusBadRequest, "invalid-body", err)
+			return
+		}
+
+		results := createBulk(store, lists, ids, rows, baseUrl, appSchemes, fetcher)
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("Rows", len(rows))
+		lgr.WithField("Errors", countBulkErrors(results))
+		lgr.Print("bulk-created")
+
+		w.Header().Set("Content-Type", bulkContentType(format))
+		writeBulk(format, w, results)
+	}
+}
+
+// bulkPost takes an uploaded file from the bulk form and returns the results as a file to download. Just like apiBulk
+// it needs an API key, which is given in the form since browsers can't send the header.
+func bulkPost(store Store, lists *blocklists, ids idGenerator, keys apiKeys, tmpl *template.Template, baseUrl string, appSchemes []string, fetcher *metaFetcher) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, bulkMaxBytes)
+		if keys.match(r.FormValue("key")) == "" {
+			renderBulk(w, tmpl, http.StatusForbidden, ErrBulkNoKey.Error())
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			renderBulk(w, tmpl, http.StatusBadRequest, ErrBulkNoFile.Error())
+			return
+		}
+		defer file.Close()
+
+		format, err := bulkFormat(header.Header.Get("Content-Type"), header.Filename)
+		if err != nil {
+			renderBulk(w, tmpl, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		rows, err := readBulk(format, file)
+		if err != nil {
+			renderBulk(w, tmpl, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		results := createBulk(store, lists, ids, rows, baseUrl, appSchemes, fetcher)
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("Rows", len(rows))
+		lgr.WithField("Errors", countBulkErrors(results))
+		lgr.Print("bulk-created")
+
+		w.Header().Set("Content-Type", bulkContentType(format))
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pow-results.%s"`, format))
+		writeBulk(format, w, results)
+	}
+}
+
+// renderBulk shows the bulk upload form, along with this error if there is one.
+func renderBulk(w http.ResponseWriter, tmpl *template.Template, status int, msg string) {
+	data := struct {
+		Error string
+	}{
+		msg,
+	}
+	renderStatus(w, tmpl, status, "bulk.html", data)
+}
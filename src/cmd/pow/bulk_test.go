@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBulkFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		filename    string
+		want        string
+	}{
+		{"text/csv; charset=utf-8", "", bulkCsv},
+		{"application/x-ndjson", "", bulkJsonl},
+		{"application/octet-stream", "links.CSV", bulkCsv},
+		{"", "links.jsonl", bulkJsonl},
+	}
+	for _, test := range tests {
+		got, err := bulkFormat(test.contentType, test.filename)
+		if err != nil || got != test.want {
+			t.Errorf("bulkFormat(%q, %q) = %q, %v, want %q", test.contentType, test.filename, got, err, test.want)
+		}
+	}
+
+	if _, err := bulkFormat("application/json", "links.txt"); err != ErrBulkFormat {
+		t.Errorf("JSON: got %v, want ErrBulkFormat", err)
+	}
+}
+
+func TestReadBulk(t *testing.T) {
+	rows, err := readBulk(bulkCsv, strings.NewReader("url,slug,notes\nhttps://example.com/a\nhttps://example.com/b, b-slug, some notes\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].in.Url != "https://example.com/a" || rows[1].in.Slug != "b-slug" || rows[1].in.Notes != "some notes" {
+		t.Errorf("CSV: got %+v", rows)
+	}
+
+	rows, err = readBulk(bulkJsonl, strings.NewReader("{\"Url\":\"https://example.com/a\"}\n\nnot json\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].err != nil || rows[1].err != ErrBulkBadRow {
+		t.Errorf("JSONL: got %+v", rows)
+	}
+
+	if _, err := readBulk(bulkCsv, strings.NewReader("url\n")); err != ErrBulkNoRows {
+		t.Errorf("no rows: got %v, want ErrBulkNoRows", err)
+	}
+	tooMany := strings.Repeat("https://example.com/\n", bulkMaxRows+1)
+	if _, err := readBulk(bulkCsv, strings.NewReader(tooMany)); err != ErrBulkTooMany {
+		t.Errorf("too many rows: got %v, want ErrBulkTooMany", err)
+	}
+}
+
+func TestApiBulk(t *testing.T) {
+	store := newTestStore(t)
+	m := newTestMux()
+	m.Post("/api/v1/urls/bulk", apiBulk(store, noLists, testIds, apiKeys{"test-key"}, "https://pow.example", nil, newMetaFetcher(store, nil)))
+
+	body := strings.Join([]string{
+		`{"Url":"https://example.com/a","Share":true}`,
+		`{"Url":"https://example.com/b","Slug":"bee"}`,
+		`{"Url":"https://example.com/c","Slug":"bee"}`,
+		`{"Url":"ftp://example.com/"}`,
+		`{"Url":"https://example.com/a","Share":true}`,
+		`{"Url":"https://example.com/a"}`,
+	}, "\n")
+
+	// without a key nothing is created
+	for _, key := range []string{"", "wrong-key"} {
+		req := httptest.NewRequest("POST", "/api/v1/urls/bulk", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		if key != "" {
+			req.Header.Set("X-Api-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "key-required") {
+			t.Errorf("key %q: got %d %s, want %d key-required", key, rec.Code, rec.Body, http.StatusForbidden)
+		}
+	}
+	if urls, _, err := store.listShortUrls("", 10); err != nil || len(urls) != 0 {
+		t.Errorf("got %d created without a key (%v)", len(urls), err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/urls/bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("X-Api-Key", "test-key")
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	results := []BulkResult{}
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		res := BulkResult{}
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	if len(results) != 6 {
+		t.Fatalf("got %d results, want 6", len(results))
+	}
+
+	// shared ShortUrls have nobody to give a token to
+	if results[0].Link == "" || results[0].Token != "" || results[0].Error != "" {
+		t.Errorf("row 1: got %+v, want a shared link", results[0])
+	}
+	if results[1].Link != "https://pow.example/bee" || results[1].Token == "" {
+		t.Errorf("row 2: got %+v", results[1])
+	}
+	if results[2].Error != ErrSlugTaken.Error() || results[2].Link != "" || results[2].Token != "" {
+		t.Errorf("row 3: got %+v, want slug taken", results[2])
+	}
+	if results[3].Error == "" || results[3].Link != "" {
+		t.Errorf("row 4: got %+v, want an invalid url", results[3])
+	}
+	if results[4].Link != results[0].Link || results[4].Token != "" {
+		t.Errorf("row 5: got %+v, want the same as row 1", results[4])
+	}
+
+	// unless asked to share, every row gets it's own ShortUrl to manage
+	if results[5].Link == results[0].Link || results[5].Token == "" {
+		t.Errorf("row 6: got %+v, want a new link with a token", results[5])
+	}
+}
+
+func TestBulkPost(t *testing.T) {
+	store := newTestStore(t)
+	m := newTestMux()
+	m.Post("/-/bulk", bulkPost(store, noLists, testIds, apiKeys{"test-key"}, newTestTemplates(t), "https://pow.example", nil, newMetaFetcher(store, nil)))
+
+	tests := []struct {
+		key    string
+		status int
+	}{
+		{"", http.StatusForbidden},
+		{"wrong-key", http.StatusForbidden},
+		{"test-key", http.StatusOK},
+	}
+	for _, test := range tests {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		mw.WriteField("key", test.key)
+		file, _ := mw.CreateFormFile("file", "links.csv")
+		file.Write([]byte("https://example.com/bulk-form\n"))
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/-/bulk", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("key %q: got %d, want %d", test.key, rec.Code, test.status)
+		}
+	}
+
+	if urls, _, err := store.listShortUrls("", 10); err != nil || len(urls) != 1 {
+		t.Errorf("got %d created (%v), want only the one with the key", len(urls), err)
+	}
+}
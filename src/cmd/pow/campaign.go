@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gomiddleware/mux"
+)
+
+const campaignMaxLen = 200
+
+var (
+	ErrCampaignIncomplete = errors.New("A campaign needs both a source and a name")
+	ErrCampaignTooLong    = errors.New("Campaign fields must be at most 200 characters long")
+	ErrCampaignNotFound   = errors.New("Campaign not found, or it has had no hits yet")
+)
+
+// Campaign is the set of UTM parameters added to a ShortUrl's destination when redirecting. Stats are also kept for
+// each campaign (by Name) a ShortUrl has had, which only it's owner can see.
+type Campaign struct {
+	Source  string // utm_source
+	Medium  string `json:",omitempty"` // utm_medium
+	Name    string // utm_campaign
+	Term    string `json:",omitempty"` // utm_term
+	Content string `json:",omitempty"` // utm_content
+}
+
+// ApiCampaign is what the API returns for a campaign.
+type ApiCampaign struct {
+	Name  string
+	Stats *Stats
+}
+
+// campaignFromForm reads a Campaign from the "utm-*" form values, or returns nil if none were given.
+func campaignFromForm(r *http.Request) *Campaign {
+	c := Campaign{
+		Source:  r.FormValue("utm-source"),
+		Medium:  r.FormValue("utm-medium"),
+		Name:    r.FormValue("utm-campaign"),
+		Term:    r.FormValue("utm-term"),
+		Content: r.FormValue("utm-content"),
+	}
+	if c == (Campaign{}) {
+		return nil
+	}
+	return &c
+}
+
+// validateCampaign trims and checks the campaign, returning nil if it is empty since that means no campaign at all.
+func validateCampaign(c *Campaign) (*Campaign, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	trimmed := Campaign{
+		Source:  strings.TrimSpace(c.Source),
+		Medium:  strings.TrimSpace(c.Medium),
+		Name:    strings.TrimSpace(c.Name),
+		Term:    strings.TrimSpace(c.Term),
+		Content: strings.TrimSpace(c.Content),
+	}
+	if trimmed == (Campaign{}) {
+		return nil, nil
+	}
+
+	if trimmed.Source == "" || trimmed.Name == "" {
+		return nil, ErrCampaignIncomplete
+	}
+	for _, str := range []string{trimmed.Source, trimmed.Medium, trimmed.Name, trimmed.Term, trimmed.Content} {
+		if len(str) > campaignMaxLen {
+			return nil, ErrCampaignTooLong
+		}
+	}
+
+	return &trimmed, nil
+}
+
+// query returns the UTM parameters for this campaign, in their usual order.
+func (c *Campaign) query() string {
+	pairs := make([]string, 0, 5)
+	add := func(name, value string) {
+		if value != "" {
+			pairs = append(pairs, name+"="+url.QueryEscape(value))
+		}
+	}
+	add("utm_source", c.Source)
+	add("utm_medium", c.Medium)
+	add("utm_campaign", c.Name)
+	add("utm_term", c.Term)
+	add("utm_content", c.Content)
+	return strings.Join(pairs, "&")
+}
+
+// withCampaign returns the target with this campaign applied, where the campaign's parameters replace any of the same
+// name already there.
+func withCampaign(target string, campaign *Campaign) (string, error) {
+	if campaign == nil {
+		return target, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery = mergeQuery(u.RawQuery, campaign.query(), queryOverride)
+	return u.String(), nil
+}
+
+// campaignKey is the key in the campaign bucket of the stats of this ShortUrl's campaign. Since ids can't contain a ':',
+// the keys of each ShortUrl are all together and start with it's campaignPrefix.
+func campaignKey(id, name string) string {
+	return campaignPrefix(id) + name
+}
+
+func campaignPrefix(id string) string {
+	return id + ":"
+}
+
+// campaignName is the name of this ShortUrl's campaign, or "" if it doesn't have one, which is recorded with each hit
+// so they still count towards the right campaign after it's changed.
+func campaignName(shortUrl *ShortUrl) string {
+	if shortUrl.Campaign == nil {
+		return ""
+	}
+	return shortUrl.Campaign.Name
+}
+
+// addCampaignHitsTx adds the hits in the hour starting at t to the stats of each campaign of this ShortUrl they were
+// recorded with.
+func addCampaignHitsTx(tx storeTx, id string, t time.Time, campaigns map[string]int64) error {
+	for name, count := range campaigns {
+		stats := Stats{}
+		err := getJson(tx, campaignBucketNameStr, campaignKey(id, name), &stats)
+		if err != nil {
+			return err
+		}
+		addHits(&stats, t, count)
+		err = putJson(tx, campaignBucketNameStr, campaignKey(id, name), stats)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// delCampaignStatsTx removes the stats of every campaign this ShortUrl has had.
+func delCampaignStatsTx(tx storeTx, id string) error {
+	keys := make([]string, 0)
+	prefix := campaignPrefix(id)
+	err := each(tx, campaignBucketNameStr, prefix, func(k string, v []byte) error {
+		if !strings.HasPrefix(k, prefix) {
+			return errStop
+		}
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if err := tx.del(campaignBucketNameStr, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCampaignStats returns the stats for this campaign of this ShortUrl, or nil if it has never had any hits.
+func (s *bucketStore) getCampaignStats(id, name string) (*Stats, error) {
+	var stats *Stats
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, campaignBucketNameStr, campaignKey(id, name), &stats)
+	})
+	return stats, err
+}
+
+// apiGetCampaign returns the stats for a campaign of a ShortUrl, but only to it's owner or an admin.
+func apiGetCampaign(store Store, adminToken string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vals := mux.Vals(r)
+		id, name := vals["id"], vals["name"]
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if shortUrl == nil {
+			sendApiError(w, http.StatusNotFound, "not-found", ErrUrlNotFound)
+			return
+		}
+		if !isOwner(shortUrl, bearerToken(r)) && !isAdmin(r, adminToken) {
+			sendApiError(w, http.StatusForbidden, "forbidden", ErrNotOwner)
+			return
+		}
+
+		stats, err := store.getCampaignStats(id, name)
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		if stats == nil {
+			sendApiError(w, http.StatusNotFound, "not-found", ErrCampaignNotFound)
+			return
+		}
+
+		sendJson(w, http.StatusOK, ApiCampaign{Name: name, Stats: stats})
+	}
+}
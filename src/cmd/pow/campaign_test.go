@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCampaign(t *testing.T) {
+	if c, err := validateCampaign(&Campaign{Source: " ", Name: ""}); c != nil || err != nil {
+		t.Errorf("empty: got %+v, %v, want no campaign", c, err)
+	}
+	if _, err := validateCampaign(&Campaign{Source: "newsletter"}); err != ErrCampaignIncomplete {
+		t.Errorf("no name: got %v, want ErrCampaignIncomplete", err)
+	}
+
+	long := strings.Repeat("a", campaignMaxLen+1)
+	if _, err := validateCampaign(&Campaign{Source: "newsletter", Name: long}); err != ErrCampaignTooLong {
+		t.Errorf("long name: got %v, want ErrCampaignTooLong", err)
+	}
+
+	c, err := validateCampaign(&Campaign{Source: " newsletter ", Name: "launch"})
+	if err != nil || c.Source != "newsletter" {
+		t.Errorf("got %+v, %v", c, err)
+	}
+}
+
+func TestWithCampaign(t *testing.T) {
+	shortUrl := ShortUrl{
+		Url:      "https://example.com/?utm_source=old&page=2",
+		Campaign: &Campaign{Source: "news letter", Medium: "email", Name: "launch"},
+	}
+
+	got, err := withCampaign(shortUrl.Url, shortUrl.Campaign)
+	want := "https://example.com/?page=2&utm_source=news+letter&utm_medium=email&utm_campaign=launch"
+	if err != nil || got != want {
+		t.Errorf("got %q, %v, want %q", got, err, want)
+	}
+
+	// and visitors can't override the campaign with their own query
+	shortUrl.QueryPassthrough = queryMerge
+	got, err = destination(&shortUrl, shortUrl.Url, "utm_campaign=mine&ref=x", "")
+	want = "https://example.com/?page=2&utm_source=news+letter&utm_medium=email&utm_campaign=launch&ref=x"
+	if err != nil || got != want {
+		t.Errorf("with passthrough: got %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestCampaignStats(t *testing.T) {
+	store := newTestStore(t)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	// two ShortUrls with the same campaign, each with an owner
+	campaign := &Campaign{Source: "newsletter", Name: "launch"}
+	ids := []string{}
+	tokens := []string{}
+	for _, dest := range []string{"https://example.com/a", "https://example.com/b"} {
+		shortUrl := ShortUrl{Url: dest, Campaign: campaign}
+		token, err := newOwnerToken(&shortUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", false); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, shortUrl.Id)
+		tokens = append(tokens, token)
+	}
+
+	// hits count towards the campaign they were recorded with, and only for that ShortUrl
+	err := rawStore(store).update(func(tx storeTx) error {
+		for i, id := range ids {
+			if err := addCampaignHitsTx(tx, id, t0, map[string]int64{"launch": int64(i + 1)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := store.getCampaignStats(ids[0], "launch")
+	if err != nil || stats == nil || stats.Total != 1 || stats.Daily["20261016"] != 1 {
+		t.Errorf("got %+v (%v), want 1 hit for the first", stats, err)
+	}
+
+	// and only it's owner or an admin can see them
+	m := newTestMux()
+	m.Get("/api/v1/urls/:id/campaigns/:name", apiGetCampaign(store, testAdminToken))
+	path := "/api/v1/urls/" + ids[1] + "/campaigns/"
+	tests := []struct {
+		token  string
+		name   string
+		status int
+		total  int64
+	}{
+		{tokens[1], "launch", http.StatusOK, 2},
+		{testAdminToken, "launch", http.StatusOK, 2},
+		{"", "launch", http.StatusForbidden, 0},
+		{tokens[0], "launch", http.StatusForbidden, 0},
+		{tokens[1], "nope", http.StatusNotFound, 0},
+	}
+	for _, test := range tests {
+		got := ApiCampaign{}
+		rec := apiRequestAs(t, m, test.token, "GET", path+test.name, "", &got)
+		if rec.Code != test.status || (test.total != 0 && (got.Stats == nil || got.Stats.Total != test.total)) {
+			t.Errorf("GET %s as %q: got %d %+v, want %d", test.name, test.token, rec.Code, got, test.status)
+		}
+	}
+	if rec := apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls/nope/campaigns/launch", "", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET nope: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const cliUsage = `Usage:
+  pow takedown ls
+  pow takedown add <id> <410|451> <reason...>
+  pow takedown rm <id>
+  pow block ls
+  pow block add <pattern> <reason...>
+  pow block rm <pattern>
+  pow broken ls
+
+These talk to the admin API of the running server at POW_ADMIN_URL (default http://localhost:$POW_PORT) using
+POW_ADMIN_TOKEN, so any changes take effect immediately.
+`
+
+// cli runs one of the admin subcommands and returns the exit code.
+func cli(args []string) int {
+	if len(args) < 2 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	var method, path string
+	var body interface{}
+
+	switch args[0] + " " + args[1] {
+	case "takedown ls":
+		method, path = "GET", "/api/v1/admin/takedowns"
+	case "takedown add":
+		if len(args) < 5 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		status, err := strconv.Atoi(args[3])
+		if err != nil {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		method, path = "POST", "/api/v1/admin/takedowns"
+		body = Takedown{Id: args[2], Status: status, Reason: strings.Join(args[4:], " ")}
+	case "takedown rm":
+		if len(args) != 3 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		method, path = "DELETE", "/api/v1/admin/takedowns/"+url.PathEscape(args[2])
+	case "block ls":
+		method, path = "GET", "/api/v1/admin/blocks"
+	case "block add":
+		if len(args) < 4 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		method, path = "POST", "/api/v1/admin/blocks"
+		body = Block{Pattern: args[2], Reason: strings.Join(args[3:], " ")}
+	case "block rm":
+		if len(args) != 3 {
+			fmt.Fprint(os.Stderr, cliUsage)
+			return 2
+		}
+		method, path = "DELETE", "/api/v1/admin/blocks?pattern="+url.QueryEscape(args[2])
+	case "broken ls":
+		method, path = "GET", "/api/v1/admin/broken"
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	adminUrl := os.Getenv("POW_ADMIN_URL")
+	if adminUrl == "" {
+		adminUrl = "http://localhost:" + os.Getenv("POW_PORT")
+	}
+
+	err := cliRequest(method, strings.TrimSuffix(adminUrl, "/")+path, os.Getenv("POW_ADMIN_TOKEN"), body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// cliRequest sends this request to the admin API and prints any response body.
+func cliRequest(method, endpoint, token string, body interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		apiErr := ApiError{}
+		if err := json.NewDecoder(res.Body).Decode(&apiErr); err != nil || apiErr.Message == "" {
+			return fmt.Errorf("unexpected response: %s", res.Status)
+		}
+		return fmt.Errorf("%s", apiErr.Message)
+	}
+
+	_, err = io.Copy(os.Stdout, res.Body)
+	return err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const notesMaxLen = 1000
+
+var ErrNotesTooLong = errors.New("Notes must be at most 1000 characters long")
+
+// FieldError is returned when one of the fields given for a new ShortUrl is invalid. Field is the form field name and
+// is used by the API to give a more specific error code.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+// newUrlFromForm reads the fields for a new ShortUrl from the form values of this request.
+func newUrlFromForm(r *http.Request) (ApiNewUrl, error) {
+	in := ApiNewUrl{
+		Url:              r.FormValue("url"),
+		Slug:             r.FormValue("slug"),
+		Password:         r.FormValue("password"),
+		Share:            r.FormValue("share") != "",
+		Title:            r.FormValue("title"),
+		Notes:            r.FormValue("notes"),
+		Tags:             parseTags(r.FormValue("tags")),
+		QueryPassthrough: r.FormValue("query-passthrough"),
+		PathPassthrough:  r.FormValue("path-passthrough") != "",
+		Campaign:         campaignFromForm(r),
+		DeepLink:         deepLinkFromForm(r),
+		Template:         r.FormValue("template"),
+	}
+
+	if str := r.FormValue("expires"); str != "" {
+		t, err := parseExpires(str, now())
+		if err != nil {
+			return in, &FieldError{"expires", err}
+		}
+		in.ExpiresAt = &t
+	}
+
+	if str := r.FormValue("max-hits"); str != "" {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return in, &FieldError{"max-hits", ErrInvalidMaxHits}
+		}
+		in.MaxHits = n
+	}
+
+	if str := r.FormValue("redirect-status"); str != "" {
+		status, err := parseRedirectStatus(str)
+		if err != nil {
+			return in, &FieldError{"redirect-status", err}
+		}
+		in.RedirectStatus = status
+	}
+
+	return in, nil
+}
+
+// newShortUrl validates everything in `in` and returns the ShortUrl ready to be saved with createShortUrl(). Deep links
+// may only use one of the appSchemes. Any validation error returned is a *FieldError, anything else is an internal
+// error.
+func newShortUrl(in ApiNewUrl, appSchemes []string, t time.Time) (*ShortUrl, error) {
+	u, err := validateUrl(in.Url)
+	if err != nil {
+		return nil, &FieldError{"url", err}
+	}
+
+	if in.Slug != "" {
+		if err := validateSlug(in.Slug); err != nil {
+			return nil, &FieldError{"slug", err}
+		}
+	}
+
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(t) {
+		return nil, &FieldError{"expires", ErrExpiryInPast}
+	}
+
+	if in.MaxHits < 0 {
+		return nil, &FieldError{"max-hits", ErrInvalidMaxHits}
+	}
+
+	if in.RedirectStatus != 0 && !isRedirectStatus(in.RedirectStatus) {
+		return nil, &FieldError{"redirect-status", ErrInvalidRedirectStatus}
+	}
+
+	if !isQueryPassthrough(in.QueryPassthrough) {
+		return nil, &FieldError{"query-passthrough", ErrInvalidQueryPassthrough}
+	}
+
+	campaign, err := validateCampaign(in.Campaign)
+	if err != nil {
+		return nil, &FieldError{"campaign", err}
+	}
+
+	rules, err := validateRules(in.Rules)
+	if err != nil {
+		return nil, &FieldError{"rules", err}
+	}
+
+	variants, err := validateVariants(in.Variants)
+	if err != nil {
+		return nil, &FieldError{"variants", err}
+	}
+
+	deepLink, err := validateDeepLink(in.DeepLink, appSchemes)
+	if err != nil {
+		return nil, &FieldError{"deep-link", err}
+	}
+
+	template, err := validateTemplate(in.Template)
+	if err != nil {
+		return nil, &FieldError{"template", err}
+	}
+
+	title, err := validateTitle(in.Title)
+	if err != nil {
+		return nil, &FieldError{"title", err}
+	}
+
+	if len(in.Notes) > notesMaxLen {
+		return nil, &FieldError{"notes", ErrNotesTooLong}
+	}
+
+	tags, err := validateTags(in.Tags)
+	if err != nil {
+		return nil, &FieldError{"tags", err}
+	}
+
+	password := ""
+	if in.Password != "" {
+		if len(in.Password) < passwordMinLen {
+			return nil, &FieldError{"password", ErrPasswordTooShort}
+		}
+		hash, err := hashPassword(in.Password)
+		if err != nil {
+			return nil, err
+		}
+		password = hash
+	}
+
+	shortUrl := ShortUrl{
+		Id:               "", // filled in when saved
+		Url:              u.String(),
+		Created:          t,
+		Updated:          t,
+		ExpiresAt:        in.ExpiresAt,
+		MaxHits:          in.MaxHits,
+		Password:         password,
+		Title:            title,
+		Notes:            in.Notes,
+		Tags:             tags,
+		RedirectStatus:   in.RedirectStatus,
+		QueryPassthrough: in.QueryPassthrough,
+		PathPassthrough:  in.PathPassthrough,
+		Campaign:         campaign,
+		Rules:            rules,
+		Variants:         variants,
+		Sticky:           in.Sticky,
+		DeepLink:         deepLink,
+		Template:         template,
+	}
+	if shortUrl.ExpiresAt != nil {
+		expiresAt := shortUrl.ExpiresAt.UTC()
+		shortUrl.ExpiresAt = &expiresAt
+	}
+
+	return &shortUrl, nil
+}
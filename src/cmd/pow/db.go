@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// idExistsTx tells you whether this id is already in use, either by a ShortUrl, a Takedown or a Tombstone of a ShortUrl
+// which has been reaped or deleted.
+func idExistsTx(tx storeTx, id string) (bool, error) {
+	for _, location := range []string{urlBucketNameStr, takedownBucketNameStr, goneBucketNameStr} {
+		v, err := tx.get(location, id)
+		if err != nil {
+			return false, err
+		}
+		if v != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// checkBlockedTx returns ErrDestinationBlocked if this destination matches any Block, or is on any of the blocklists.
+func checkBlockedTx(tx storeTx, lists *blocklists, str string) error {
+	block, err := findBlockTx(tx, str)
+	if err != nil {
+		return err
+	}
+	if block != nil {
+		return ErrDestinationBlocked
+	}
+	return lists.check(str)
+}
+
+// createShortUrl saves this shortUrl into the url bucket. If a slug is given it is used as the Id (returning
+// ErrSlugTaken if it already exists), otherwise a new unique Id is made by ids. Returns ErrDestinationBlocked if the
+// destination is blocked, either by a Block or by one of the blocklists.
+//
+// If reuse is true and a plain ShortUrl already exists for this destination, that one is put into shortUrl instead
+// and true is returned.
+func (s *bucketStore) createShortUrl(lists *blocklists, ids idGenerator, shortUrl *ShortUrl, slug string, reuse bool) (bool, error) {
+	reused := false
+	err := s.update(func(tx storeTx) error {
+		var err error
+		reused, err = createShortUrlTx(tx, lists, ids, shortUrl, slug, reuse)
+		return err
+	})
+	return reused, err
+}
+
+// pendingShortUrl is one of the ShortUrls given to createShortUrls, along with how it went.
+type pendingShortUrl struct {
+	shortUrl *ShortUrl
+	slug     string
+	reuse    bool
+
+	reused bool
+	err    error // ErrSlugTaken or ErrDestinationBlocked if it wasn't created
+}
+
+// createShortUrls creates each of these ShortUrls just like createShortUrl, all within one transaction. A ShortUrl whose
+// slug is taken or whose destination is blocked is skipped with that err, but any other error means none of them were
+// created.
+func (s *bucketStore) createShortUrls(lists *blocklists, ids idGenerator, pending []*pendingShortUrl) error {
+	return s.update(func(tx storeTx) error {
+		for _, p := range pending {
+			p.reused, p.err = createShortUrlTx(tx, lists, ids, p.shortUrl, p.slug, p.reuse)
+			if p.err == ErrSlugTaken || p.err == ErrDestinationBlocked {
+				continue
+			}
+			if p.err != nil {
+				return p.err
+			}
+		}
+		return nil
+	})
+}
+
+func createShortUrlTx(tx storeTx, lists *blocklists, ids idGenerator, shortUrl *ShortUrl, slug string, reuse bool) (bool, error) {
+	var id string
+
+	for _, dest := range shortUrl.destinations() {
+		if err := checkBlockedTx(tx, lists, dest); err != nil {
+			return false, err
+		}
+	}
+
+	if reuse {
+		existing, err := findDuplicateTx(tx, shortUrl.Url)
+		if err != nil {
+			return false, err
+		}
+		if existing != nil {
+			*shortUrl = *existing
+			return true, nil
+		}
+	}
+
+	if slug != "" {
+		exists, err := idExistsTx(tx, slug)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return false, ErrSlugTaken
+		}
+		id = slug
+	} else {
+		// keep generating IDs until we find a unique one
+		for collisions := 0; ; collisions++ {
+			// generate a new Id
+			var err error
+			id, err = ids.generate(tx, collisions)
+			if err != nil {
+				return false, err
+			}
+			fmt.Printf("id=%s\n", id)
+
+			// see if it already exists
+			exists, err := idExistsTx(tx, id)
+			if err != nil {
+				return false, err
+			}
+			if !exists {
+				// this id does not yet exist, so quit the loop
+				break
+			}
+			// ID exists, loop again ...
+		}
+	}
+
+	shortUrl.Id = id
+	if err := putJson(tx, urlBucketNameStr, id, shortUrl); err != nil {
+		return false, err
+	}
+	if err := reindexTagsTx(tx, id, nil, shortUrl.Tags); err != nil {
+		return false, err
+	}
+
+	// only plain ShortUrls can be shared with others
+	if reuse && shortUrl.isPlain() {
+		if err := indexDestTx(tx, shortUrl); err != nil {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// getShortUrl returns the ShortUrl for this id, or nil if it doesn't exist.
+func (s *bucketStore) getShortUrl(id string) (*ShortUrl, error) {
+	var shortUrl *ShortUrl
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, urlBucketNameStr, id, &shortUrl)
+	})
+	return shortUrl, err
+}
+
+// putShortUrl saves this ShortUrl just as it is, replacing any with the same Id. Nothing is checked or indexed, so
+// this is only for tools and tests, and everything else should use createShortUrl or updateShortUrl.
+func (s *bucketStore) putShortUrl(shortUrl *ShortUrl) error {
+	return s.update(func(tx storeTx) error {
+		return putJson(tx, urlBucketNameStr, shortUrl.Id, shortUrl)
+	})
+}
+
+// getStats returns the Stats for this id. If no stats have been aggregated yet, an empty Stats is returned.
+func (s *bucketStore) getStats(id string) (*Stats, error) {
+	stats := Stats{}
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, statsBucketNameStr, id, &stats)
+	})
+	return &stats, err
+}
+
+// listShortUrls returns up to limit ShortUrls (with their stats) in Id order, starting after the Id given in cursor.
+// If there are more to come, the Id of the last one returned is also returned as the next cursor, otherwise "".
+func (s *bucketStore) listShortUrls(cursor string, limit int) ([]*ApiUrl, string, error) {
+	urls := make([]*ApiUrl, 0)
+	next := ""
+
+	err := s.view(func(tx storeTx) error {
+		return each(tx, urlBucketNameStr, cursor, func(id string, v []byte) error {
+			if len(urls) == limit {
+				next = urls[len(urls)-1].Id
+				return errStop
+			}
+
+			apiUrl := ApiUrl{Stats: &Stats{}}
+			if err := json.Unmarshal(v, &apiUrl.ShortUrl); err != nil {
+				return err
+			}
+			if err := getJson(tx, statsBucketNameStr, apiUrl.Id, apiUrl.Stats); err != nil {
+				return err
+			}
+			urls = append(urls, &apiUrl)
+			return nil
+		})
+	})
+
+	return urls, next, err
+}
+
+func getHitsTx(tx storeTx, id string) (int64, error) {
+	str, err := getString(tx, hitsBucketNameStr, id)
+	if err != nil {
+		return 0, err
+	}
+	if str == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(str, 10, 64)
+}
+
+// getHits returns the number of times this ShortUrl has been used. This is only counted for ShortUrls with a MaxHits.
+func (s *bucketStore) getHits(id string) (int64, error) {
+	var hits int64
+	err := s.view(func(tx storeTx) error {
+		var err error
+		hits, err = getHitsTx(tx, id)
+		return err
+	})
+	return hits, err
+}
+
+// useHit counts one more hit for this ShortUrl, but only if it hasn't already been used up. Returns whether the hit
+// was allowed.
+func (s *bucketStore) useHit(shortUrl *ShortUrl) (bool, error) {
+	ok := false
+	err := s.update(func(tx storeTx) error {
+		hits, err := getHitsTx(tx, shortUrl.Id)
+		if err != nil {
+			return err
+		}
+		if shortUrl.isUsedUp(hits) {
+			return nil
+		}
+
+		ok = true
+		return putString(tx, hitsBucketNameStr, shortUrl.Id, strconv.FormatInt(hits+1, 10))
+	})
+	return ok, err
+}
+
+// reapExpired removes all ShortUrls (and their stats) which have expired or been used up at time t, leaving a Tombstone
+// in their place. Returns how many were removed.
+func (s *bucketStore) reapExpired(t time.Time) (int, error) {
+	reaped := make([]*Tombstone, 0)
+
+	err := s.update(func(tx storeTx) error {
+		// find them all first, since we shouldn't delete from a bucket we are iterating over
+		err := each(tx, urlBucketNameStr, "", func(id string, v []byte) error {
+			shortUrl := ShortUrl{}
+			if err := json.Unmarshal(v, &shortUrl); err != nil {
+				return err
+			}
+			hits, err := getHitsTx(tx, shortUrl.Id)
+			if err != nil {
+				return err
+			}
+			if shortUrl.hasExpired(t) {
+				reaped = append(reaped, &Tombstone{Id: shortUrl.Id, Reason: expiredReason, Created: t})
+			} else if shortUrl.isUsedUp(hits) {
+				reaped = append(reaped, &Tombstone{Id: shortUrl.Id, Reason: usedUpReason, Created: t})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, tombstone := range reaped {
+			if err := deleteShortUrlTx(tx, tombstone); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return len(reaped), err
+}
+
+// updateShortUrl gets the ShortUrl for this id and calls fn to change it, then saves it, all within one transaction.
+// If fn returns an error nothing is saved and that error is returned. If the ShortUrl doesn't exist, nil is returned.
+// Returns ErrDestinationBlocked if the destination was changed to one which is blocked, either by a Block or by one of
+// the blocklists.
+func (s *bucketStore) updateShortUrl(lists *blocklists, id string, fn func(*ShortUrl) error) (*ShortUrl, error) {
+	var shortUrl *ShortUrl
+	err := s.update(func(tx storeTx) error {
+		err := getJson(tx, urlBucketNameStr, id, &shortUrl)
+		if err != nil {
+			return err
+		}
+		if shortUrl == nil {
+			return nil
+		}
+
+		prev := shortUrl.Url
+		prevDests := shortUrl.destinations()
+		prevTags := shortUrl.Tags
+		err = fn(shortUrl)
+		if err != nil {
+			return err
+		}
+
+		// only new destinations are checked, so a ShortUrl to a since blocked destination can still be disabled
+		for _, dest := range shortUrl.destinations() {
+			if containsString(prevDests, dest) {
+				continue
+			}
+			if err := checkBlockedTx(tx, lists, dest); err != nil {
+				return err
+			}
+		}
+		if shortUrl.Url != prev {
+			if err := unindexDestTx(tx, id, prev); err != nil {
+				return err
+			}
+		}
+		if err := reindexTagsTx(tx, id, prevTags, shortUrl.Tags); err != nil {
+			return err
+		}
+
+		return putJson(tx, urlBucketNameStr, id, shortUrl)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return shortUrl, nil
+}
+
+// deleteShortUrl removes this ShortUrl and everything we know about it at time t, leaving a Tombstone in it's place so
+// the Id isn't given out again.
+func (s *bucketStore) deleteShortUrl(id string, t time.Time) error {
+	return s.update(func(tx storeTx) error {
+		return deleteShortUrlTx(tx, &Tombstone{Id: id, Reason: deletedReason, Created: t})
+	})
+}
+
+func deleteShortUrlTx(tx storeTx, tombstone *Tombstone) error {
+	id := tombstone.Id
+
+	var shortUrl *ShortUrl
+	if err := getJson(tx, urlBucketNameStr, id, &shortUrl); err != nil {
+		return err
+	}
+	if shortUrl != nil {
+		if err := unindexDestTx(tx, id, shortUrl.Url); err != nil {
+			return err
+		}
+		if err := reindexTagsTx(tx, id, shortUrl.Tags, nil); err != nil {
+			return err
+		}
+	}
+
+	locations := []string{urlBucketNameStr, statsBucketNameStr, hitsBucketNameStr, metaBucketNameStr, healthBucketNameStr}
+	for _, location := range locations {
+		if err := tx.del(location, id); err != nil {
+			return err
+		}
+	}
+	if err := delCampaignStatsTx(tx, id); err != nil {
+		return err
+	}
+	return putJson(tx, goneBucketNameStr, id, tombstone)
+}
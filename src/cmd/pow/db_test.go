@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// noLists are empty blocklists, for tests which don't need any.
+var noLists = newBlocklists("")
+
+// testIds makes IDs just like the server does by default.
+var testIds = newRandomIds(idAlphabets["letters"], idDefaultLen)
+
+// newTestStore opens a new Bolt store with the same buckets as the server, which is removed after the test.
+func newTestStore(t *testing.T) Store {
+	store, err := openBoltStore(filepath.Join(t.TempDir(), "pow.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.close() })
+	return newBucketStore(store)
+}
+
+// rawStore returns the kvStore under this Store, for tests which need to look at or change it's buckets themselves.
+func rawStore(store Store) kvStore {
+	return store.(*bucketStore).kvStore
+}
+
+func TestListShortUrlsPages(t *testing.T) {
+	store := newTestStore(t)
+	for i := 0; i < 5; i++ {
+		shortUrl := ShortUrl{Url: "https://example.com/"}
+		if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids := []string{}
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		urls, next, err := store.listShortUrls(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(urls) > 2 {
+			t.Fatalf("got %d urls, want at most 2", len(urls))
+		}
+		for _, apiUrl := range urls {
+			ids = append(ids, apiUrl.Id)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if len(ids) != 5 {
+		t.Fatalf("listed %d urls, want 5: %v", len(ids), ids)
+	}
+	for i := 1; i < len(ids); i++ {
+		if strings.Compare(ids[i-1], ids[i]) >= 0 {
+			t.Errorf("not in Id order: %v", ids)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// normalizeUrl returns the form of this destination used for the reverse index, so that trivially different URLs
+// (such as "HTTPS://Example.com:443" and "https://example.com/") are treated as the same destination.
+func normalizeUrl(str string) string {
+	u, err := url.Parse(str)
+	if err != nil {
+		return str
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery != "" {
+		// sorts by key
+		u.RawQuery = u.Query().Encode()
+	}
+
+	return u.String()
+}
+
+// isPlain tells you whether this ShortUrl does nothing more than redirect, i.e. it could be shared by anyone who wants
+// to shorten the same destination. ShortUrls with an Owner never are, since they could change where it goes for
+// everyone.
+func (s *ShortUrl) isPlain() bool {
+	return s.Owner == "" && s.ExpiresAt == nil && s.MaxHits == 0 && s.Password == "" && !s.Disabled && s.Notes == "" &&
+		s.Title == "" && len(s.Tags) == 0 && s.RedirectStatus == 0 && s.QueryPassthrough == "" && !s.PathPassthrough &&
+		s.Campaign == nil && len(s.Rules) == 0 &&
+		len(s.Variants) == 0 && s.DeepLink == nil &&
+		s.Template == ""
+}
+
+// wantsReuse tells you whether a request for a new ShortUrl asked to Share an existing ShortUrl for the same destination,
+// and is plain enough to. If so, the new ShortUrl is shared too, so mustn't be given an Owner. Otherwise every new
+// ShortUrl has an Owner, who can fix or delete it.
+func wantsReuse(in ApiNewUrl, shortUrl *ShortUrl) bool {
+	return in.Share && in.Slug == "" && shortUrl.isPlain()
+}
+
+// findDuplicateTx returns the existing ShortUrl for this destination, if there is one and it can still be shared.
+func findDuplicateTx(tx storeTx, str string) (*ShortUrl, error) {
+	id, err := getString(tx, destBucketNameStr, normalizeUrl(str))
+	if err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return nil, nil
+	}
+
+	var shortUrl *ShortUrl
+	err = getJson(tx, urlBucketNameStr, id, &shortUrl)
+	if err != nil {
+		return nil, err
+	}
+	if shortUrl == nil || !shortUrl.isPlain() || normalizeUrl(shortUrl.Url) != normalizeUrl(str) {
+		return nil, nil
+	}
+
+	takedown, err := tx.get(takedownBucketNameStr, id)
+	if err != nil {
+		return nil, err
+	}
+	if takedown != nil {
+		return nil, nil
+	}
+
+	return shortUrl, nil
+}
+
+// indexDestTx makes this ShortUrl the one returned for it's destination.
+func indexDestTx(tx storeTx, shortUrl *ShortUrl) error {
+	return putString(tx, destBucketNameStr, normalizeUrl(shortUrl.Url), shortUrl.Id)
+}
+
+// unindexDestTx removes this destination from the index, but only if it still points to this ShortUrl.
+func unindexDestTx(tx storeTx, id, str string) error {
+	key := normalizeUrl(str)
+	current, err := getString(tx, destBucketNameStr, key)
+	if err != nil {
+		return err
+	}
+	if current != id {
+		return nil
+	}
+	return tx.del(destBucketNameStr, key)
+}
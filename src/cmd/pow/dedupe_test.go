@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeUrl(t *testing.T) {
+	tests := []struct {
+		str  string
+		want string
+	}{
+		{"HTTPS://Example.COM:443", "https://example.com/"},
+		{"http://example.com:80/path", "http://example.com/path"},
+		{"http://example.com:8080/", "http://example.com:8080/"},
+		{"https://example.com/?b=2&a=1", "https://example.com/?a=1&b=2"},
+		{"https://example.com/Path", "https://example.com/Path"},
+	}
+	for _, test := range tests {
+		if got := normalizeUrl(test.str); got != test.want {
+			t.Errorf("normalizeUrl(%q) = %q, want %q", test.str, got, test.want)
+		}
+	}
+}
+
+func TestApiDedupe(t *testing.T) {
+	store := newTestStore(t)
+	m := newTestApiFor(store)
+
+	// every new ShortUrl has an owner unless it's asked to be shared
+	owned := ApiUrl{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/?b=2&a=1"}`, &owned)
+	if rec.Code != http.StatusCreated || owned.Token == "" || owned.ManageLink == "" {
+		t.Fatalf("owned: got %d %+v, want 201 with a token", rec.Code, owned)
+	}
+
+	first := ApiUrl{}
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/?b=2&a=1","Share":true}`, &first)
+	if rec.Code != http.StatusCreated || first.Token != "" || first.ManageLink != "" {
+		t.Fatalf("first: got %d %+v, want 201 with no token", rec.Code, first)
+	}
+
+	again := ApiUrl{}
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"HTTPS://EXAMPLE.com/?a=1&b=2","Share":true}`, &again)
+	if first.Id == owned.Id || rec.Code != http.StatusOK || again.Id != first.Id || again.Token != "" || again.ManageLink != "" {
+		t.Errorf("again: got %d %+v, want 200 with %s and no token", rec.Code, again, first.Id)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not shared", `{"Url":"https://example.com/?a=1&b=2"}`},
+		{"slug", `{"Url":"https://example.com/?a=1&b=2","Slug":"mine","Share":true}`},
+		{"password", `{"Url":"https://example.com/?a=1&b=2","Password":"open sesame","Share":true}`},
+		{"max hits", `{"Url":"https://example.com/?a=1&b=2","MaxHits":3,"Share":true}`},
+	}
+	for _, test := range tests {
+		got := ApiUrl{}
+		rec := apiRequest(t, m, "POST", "/api/v1/urls", test.body, &got)
+		if rec.Code != http.StatusCreated || got.Id == first.Id || got.Token == "" {
+			t.Errorf("%s: got %d %+v, want a new ShortUrl", test.name, rec.Code, got)
+		}
+	}
+
+	// once it's gone from the index, the next create gets a new ShortUrl
+	if err := store.deleteShortUrl(first.Id, now()); err != nil {
+		t.Fatal(err)
+	}
+	after := ApiUrl{}
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/?a=1&b=2","Share":true}`, &after)
+	if rec.Code != http.StatusCreated || after.Id == first.Id {
+		t.Errorf("after delete: got %d %+v", rec.Code, after)
+	}
+}
+
+func TestDedupeNeverShared(t *testing.T) {
+	store := newTestStore(t)
+	m := newTestApiFor(store)
+
+	// a ShortUrl someone can manage, which somehow made it into the index
+	owned := ApiUrl{}
+	apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/"}`, &owned)
+	err := rawStore(store).update(func(tx storeTx) error {
+		return indexDestTx(tx, &owned.ShortUrl)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// isn't given to anyone else, since its owner could send them somewhere else
+	first := ApiUrl{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/","Share":true}`, &first)
+	if rec.Code != http.StatusCreated || first.Id == owned.Id {
+		t.Fatalf("first: got %d %+v, want a new ShortUrl", rec.Code, first)
+	}
+
+	// and the first anonymous creator can't change the one they now share with the second
+	second := ApiUrl{}
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/","Share":true}`, &second)
+	if rec.Code != http.StatusOK || second.Id != first.Id {
+		t.Fatalf("second: got %d %+v, want %s", rec.Code, second, first.Id)
+	}
+	for _, token := range []string{first.Token, owned.Token} {
+		rec = apiRequestAs(t, m, token, "PATCH", "/api/v1/urls/"+first.Id, `{"Url":"https://evil.example/"}`, nil)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("PATCH with %q: got %d, want %d", token, rec.Code, http.StatusForbidden)
+		}
+	}
+	shortUrl, err := store.getShortUrl(first.Id)
+	if err != nil || shortUrl.Url != "https://example.com/" || shortUrl.Owner != "" {
+		t.Errorf("got %+v (%v)", shortUrl, err)
+	}
+}
+
+func TestDedupeSkipsChanged(t *testing.T) {
+	store := newTestStore(t)
+
+	shortUrl := ShortUrl{Url: "https://example.com/a"}
+	if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", true); err != nil {
+		t.Fatal(err)
+	}
+	_, err := store.updateShortUrl(noLists, shortUrl.Id, func(shortUrl *ShortUrl) error {
+		return changeDestination(shortUrl, "https://example.com/b", now())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the old nor the new destination reuse it, since it's no longer plainly for either
+	for _, dest := range []string{"https://example.com/a", "https://example.com/b"} {
+		other := ShortUrl{Url: dest}
+		reused, err := store.createShortUrl(noLists, testIds, &other, "", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reused {
+			t.Errorf("%s: reused %s after it's destination changed", dest, other.Id)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrAppSchemeNotAllowed = errors.New("App links must use one of this server's app schemes")
+	ErrNoAppSchemes        = errors.New("This server doesn't allow app links")
+	ErrInvalidAppScheme    = errors.New("App schemes must be custom schemes such as myapp")
+)
+
+var schemeRegExp = regexp.MustCompile(`^[a-z][a-z0-9+.-]*$`)
+
+// these can never be app schemes, since they'd let a ShortUrl do far more than open an app
+var forbiddenAppSchemes = []string{"http", "https", "javascript", "data", "vbscript", "file", "about", "blob"}
+
+// DeepLink opens a mobile app, falling back to the app's store page if it isn't installed. Desktops (and phones with
+// no store page given) go to the ShortUrl's Url as usual.
+type DeepLink struct {
+	App          string // e.g. myapp://product/123
+	IosStore     string `json:",omitempty"` // e.g. https://apps.apple.com/app/id123
+	AndroidStore string `json:",omitempty"` // e.g. https://play.google.com/store/apps/details?id=com.example
+}
+
+// parseAppSchemes parses the comma separated list of app schemes from POW_APP_SCHEMES.
+func parseAppSchemes(str string) ([]string, error) {
+	schemes := make([]string, 0)
+	for _, scheme := range strings.Split(str, ",") {
+		scheme = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(scheme), "://"))
+		if scheme == "" {
+			continue
+		}
+		if !schemeRegExp.MatchString(scheme) || containsString(forbiddenAppSchemes, scheme) {
+			return nil, ErrInvalidAppScheme
+		}
+		schemes = append(schemes, scheme)
+	}
+	return schemes, nil
+}
+
+// validateAppUrl is like validateUrl, but for app links, which must use one of the configured schemes.
+func validateAppUrl(str string, schemes []string) (*url.URL, error) {
+	if len(schemes) == 0 {
+		return nil, ErrNoAppSchemes
+	}
+
+	u, err := url.Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(schemes, strings.ToLower(u.Scheme)) {
+		return nil, ErrAppSchemeNotAllowed
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	return u, nil
+}
+
+// validateDeepLink checks the deep link and returns it with it's Urls normalised, or nil if it's empty.
+func validateDeepLink(dl *DeepLink, schemes []string) (*DeepLink, error) {
+	if dl == nil || *dl == (DeepLink{}) {
+		return nil, nil
+	}
+
+	app, err := validateAppUrl(dl.App, schemes)
+	if err != nil {
+		return nil, err
+	}
+	valid := DeepLink{App: app.String()}
+
+	if dl.IosStore != "" {
+		u, err := validateUrl(dl.IosStore)
+		if err != nil {
+			return nil, err
+		}
+		valid.IosStore = u.String()
+	}
+	if dl.AndroidStore != "" {
+		u, err := validateUrl(dl.AndroidStore)
+		if err != nil {
+			return nil, err
+		}
+		valid.AndroidStore = u.String()
+	}
+
+	return &valid, nil
+}
+
+// deepLinkFromForm reads a DeepLink from the form values, or returns nil if none were given.
+func deepLinkFromForm(r *http.Request) *DeepLink {
+	dl := DeepLink{
+		App:          r.FormValue("app-url"),
+		IosStore:     r.FormValue("ios-store"),
+		AndroidStore: r.FormValue("android-store"),
+	}
+	if dl == (DeepLink{}) {
+		return nil
+	}
+	return &dl
+}
+
+// opensApp tells you whether this visitor should be sent to the app rather than straight to dest.
+func (dl *DeepLink) opensApp(platform string) bool {
+	return dl != nil && (platform == platformIos || platform == platformAndroid)
+}
+
+// fallback returns where to go if the app doesn't open, which is the store for this platform if there is one.
+func (dl *DeepLink) fallback(platform, dest string) string {
+	if platform == platformIos && dl.IosStore != "" {
+		return dl.IosStore
+	}
+	if platform == platformAndroid && dl.AndroidStore != "" {
+		return dl.AndroidStore
+	}
+	return dest
+}
+
+// reachable returns everywhere this visitor could end up: dest, and the store it falls back to if the app is opened.
+// Each of them has to pass the same checks before the visitor is sent anywhere.
+func (dl *DeepLink) reachable(platform, dest string) []string {
+	if !dl.opensApp(platform) {
+		return []string{dest}
+	}
+	if fallback := dl.fallback(platform, dest); fallback != dest {
+		return []string{dest, fallback}
+	}
+	return []string{dest}
+}
+
+// openApp renders the interstitial page which tries to open the app and otherwise goes to the fallback.
+func openApp(w http.ResponseWriter, tmpl *template.Template, dl *DeepLink, platform, dest string) {
+	data := struct {
+		App      template.URL // already checked against our app schemes
+		Fallback string
+	}{
+		template.URL(dl.App),
+		dl.fallback(platform, dest),
+	}
+
+	w.Header().Set("Cache-Control", "private, no-cache")
+	render(w, tmpl, "deeplink.html", data)
+}
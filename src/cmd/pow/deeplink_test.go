@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseAppSchemes(t *testing.T) {
+	schemes, err := parseAppSchemes(" MyApp://, other-app ,, ")
+	if err != nil || strings.Join(schemes, ",") != "myapp,other-app" {
+		t.Errorf("got %v, %v", schemes, err)
+	}
+
+	for _, str := range []string{"https", "javascript:", "my app", "9app"} {
+		if _, err := parseAppSchemes(str); err != ErrInvalidAppScheme {
+			t.Errorf("parseAppSchemes(%q): got %v, want ErrInvalidAppScheme", str, err)
+		}
+	}
+}
+
+func TestValidateDeepLink(t *testing.T) {
+	schemes := []string{"myapp"}
+
+	if dl, err := validateDeepLink(&DeepLink{}, schemes); dl != nil || err != nil {
+		t.Errorf("empty: got %+v, %v", dl, err)
+	}
+
+	dl, err := validateDeepLink(&DeepLink{App: "MYAPP://product/123", IosStore: "https://apps.apple.com/app/id123"}, schemes)
+	if err != nil || dl.App != "myapp://product/123" || dl.IosStore != "https://apps.apple.com/app/id123" {
+		t.Errorf("valid: got %+v, %v", dl, err)
+	}
+
+	tests := []struct {
+		dl      DeepLink
+		schemes []string
+		err     error
+	}{
+		{DeepLink{App: "myapp://x"}, nil, ErrNoAppSchemes},
+		{DeepLink{App: "otherapp://x"}, schemes, ErrAppSchemeNotAllowed},
+		{DeepLink{App: "javascript:alert(1)"}, schemes, ErrAppSchemeNotAllowed},
+		{DeepLink{App: "myapp://x", AndroidStore: "ftp://example.com/"}, schemes, ErrInvalidScheme},
+	}
+	for _, test := range tests {
+		if _, err := validateDeepLink(&test.dl, test.schemes); err != test.err {
+			t.Errorf("%+v: got %v, want %v", test.dl, err, test.err)
+		}
+	}
+}
+
+func TestDeepLinkFallback(t *testing.T) {
+	dl := &DeepLink{App: "myapp://x", IosStore: "https://apps.apple.com/app/id123"}
+	tests := []struct {
+		platform  string
+		opens     bool
+		want      string
+		reachable []string
+	}{
+		{platformIos, true, dl.IosStore, []string{"https://example.com/", dl.IosStore}},
+		{platformAndroid, true, "https://example.com/", []string{"https://example.com/"}},
+		{"", false, "https://example.com/", []string{"https://example.com/"}},
+	}
+	for _, test := range tests {
+		if got := dl.opensApp(test.platform); got != test.opens {
+			t.Errorf("%q: opensApp got %v, want %v", test.platform, got, test.opens)
+		}
+		if got := dl.fallback(test.platform, "https://example.com/"); got != test.want {
+			t.Errorf("%q: fallback got %s, want %s", test.platform, got, test.want)
+		}
+		if got := dl.reachable(test.platform, "https://example.com/"); strings.Join(got, " ") != strings.Join(test.reachable, " ") {
+			t.Errorf("%q: reachable got %v, want %v", test.platform, got, test.reachable)
+		}
+	}
+
+	var none *DeepLink
+	if none.opensApp(platformIos) {
+		t.Error("no deep link opens the app")
+	}
+	if got := none.reachable(platformIos, "https://example.com/"); len(got) != 1 {
+		t.Errorf("no deep link: reachable got %v", got)
+	}
+}
+
+func TestOpenApp(t *testing.T) {
+	rec := httptest.NewRecorder()
+	dl := &DeepLink{App: "myapp://product/123", IosStore: "https://apps.apple.com/app/id123"}
+	openApp(rec, newTestTemplates(t), dl, platformIos, "https://example.com/")
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `href="myapp://product/123"`) || !strings.Contains(body, "https://apps.apple.com/app/id123") {
+		t.Errorf("got %s", body)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != "private, no-cache" {
+		t.Errorf("got Cache-Control %q", cc)
+	}
+}
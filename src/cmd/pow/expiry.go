@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidExpiry  = errors.New("Expiry must be a duration (e.g. 12h or 7d) or a date (e.g. 2006-01-02 or 2006-01-02T15:04:05Z)")
+	ErrExpiryInPast   = errors.New("Expiry must be in the future")
+	ErrInvalidMaxHits = errors.New("Max hits must be a positive number, or zero for unlimited")
+)
+
+// The reasons visitors are given for a ShortUrl being gone, whether or not it's been reaped yet.
+const (
+	expiredReason = "This link has expired."
+	usedUpReason  = "This link has been used the maximum number of times."
+	deletedReason = "This link has been deleted by its owner."
+)
+
+// parseExpires accepts either a duration relative to t (Go durations such as "12h", or a number of days such as
+// "7d"), or an absolute date in either "2006-01-02" or RFC3339 format.
+func parseExpires(str string, t time.Time) (time.Time, error) {
+	if strings.HasSuffix(str, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(str, "d"))
+		if err == nil {
+			return t.AddDate(0, 0, days), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(str); err == nil {
+		return t.Add(d), nil
+	}
+
+	if expiresAt, err := time.Parse("2006-01-02", str); err == nil {
+		return expiresAt, nil
+	}
+
+	if expiresAt, err := time.Parse(time.RFC3339, str); err == nil {
+		return expiresAt.UTC(), nil
+	}
+
+	return time.Time{}, ErrInvalidExpiry
+}
+
+// hasExpired tells you whether this ShortUrl is past it's expiry date at time t.
+func (s *ShortUrl) hasExpired(t time.Time) bool {
+	return s.ExpiresAt != nil && !t.Before(*s.ExpiresAt)
+}
+
+// isUsedUp tells you whether this ShortUrl has already been hit it's maximum number of times.
+func (s *ShortUrl) isUsedUp(hits int64) bool {
+	return s.MaxHits > 0 && hits >= s.MaxHits
+}
+
+// expiresIn returns a friendly description of the time remaining until d has elapsed, e.g. "3 days, 4 hours".
+func expiresIn(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	mins := int(d/time.Minute) % 60
+
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	if days > 0 {
+		return plural(days, "day") + ", " + plural(hours, "hour")
+	}
+	if hours > 0 {
+		return plural(hours, "hour") + ", " + plural(mins, "minute")
+	}
+	return plural(mins, "minute")
+}
+
+// getTombstone returns what's left of the ShortUrl with this id if it was reaped or deleted, otherwise nil.
+func (s *bucketStore) getTombstone(id string) (*Tombstone, error) {
+	var tombstone *Tombstone
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, goneBucketNameStr, id, &tombstone)
+	})
+	return tombstone, err
+}
+
+// reaper regularly removes any ShortUrls which have either expired or been used up.
+func reaper(store Store) {
+	duration := time.Duration(10) * time.Minute
+
+	ticker := time.NewTicker(duration)
+	for range ticker.C {
+		n, err := store.reapExpired(now())
+		if err != nil {
+			log.Printf("reaper: %s\n", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("reaper: removed %d expired short urls\n", n)
+		}
+	}
+}
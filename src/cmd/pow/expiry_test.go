@@ -0,0 +1,180 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTemplates loads the same templates as the server.
+func newTestTemplates(t *testing.T) *template.Template {
+	tmpl, err := template.New("").ParseGlob("../../../templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+func TestParseExpires(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		str  string
+		want time.Time
+	}{
+		{"7d", t0.AddDate(0, 0, 7)},
+		{"12h", t0.Add(12 * time.Hour)},
+		{"90m", t0.Add(90 * time.Minute)},
+		{"2027-01-02", time.Date(2027, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"2027-01-02T15:04:05+01:00", time.Date(2027, 1, 2, 14, 4, 5, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parseExpires(test.str, t0)
+		if err != nil || !got.Equal(test.want) {
+			t.Errorf("parseExpires(%q) = %v, %v, want %v", test.str, got, err, test.want)
+		}
+	}
+
+	for _, str := range []string{"soon", "7days", "2027-13-01"} {
+		if _, err := parseExpires(str, t0); err != ErrInvalidExpiry {
+			t.Errorf("parseExpires(%q) = %v, want ErrInvalidExpiry", str, err)
+		}
+	}
+}
+
+func TestNewShortUrlExpiry(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	past := t0.Add(-time.Minute)
+
+	_, err := newShortUrl(ApiNewUrl{Url: "https://example.com/", ExpiresAt: &past}, nil, t0)
+	if fieldErr, ok := err.(*FieldError); !ok || fieldErr.Field != "expires" || fieldErr.Err != ErrExpiryInPast {
+		t.Errorf("expiry in the past: got %v", err)
+	}
+
+	_, err = newShortUrl(ApiNewUrl{Url: "https://example.com/", MaxHits: -1}, nil, t0)
+	if fieldErr, ok := err.(*FieldError); !ok || fieldErr.Field != "max-hits" {
+		t.Errorf("negative max hits: got %v", err)
+	}
+}
+
+func TestHasExpired(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	shortUrl := ShortUrl{ExpiresAt: &t0}
+
+	if shortUrl.hasExpired(t0.Add(-time.Second)) {
+		t.Error("expired before it's expiry")
+	}
+	if !shortUrl.hasExpired(t0) {
+		t.Error("not expired at it's expiry")
+	}
+	if (&ShortUrl{}).hasExpired(t0) {
+		t.Error("expired without an expiry")
+	}
+}
+
+func TestUseHit(t *testing.T) {
+	store := newTestStore(t)
+	shortUrl := ShortUrl{Url: "https://example.com/", MaxHits: 2}
+	if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []bool{true, true, false, false} {
+		ok, err := store.useHit(&shortUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("hit %d: got %v, want %v", i+1, ok, want)
+		}
+	}
+
+	hits, err := store.getHits(shortUrl.Id)
+	if err != nil || hits != 2 || !shortUrl.isUsedUp(hits) {
+		t.Errorf("got %d hits (%v), want 2 and used up", hits, err)
+	}
+}
+
+func TestReapExpired(t *testing.T) {
+	store := newTestStore(t)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	later := t0.Add(time.Hour)
+
+	expired := ShortUrl{Url: "https://example.com/expired", ExpiresAt: &t0}
+	usedUp := ShortUrl{Url: "https://example.com/used-up", MaxHits: 1}
+	live := ShortUrl{Url: "https://example.com/live", ExpiresAt: &later, MaxHits: 1}
+	for _, shortUrl := range []*ShortUrl{&expired, &usedUp, &live} {
+		if _, err := store.createShortUrl(noLists, testIds, shortUrl, "", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.useHit(&usedUp); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := store.reapExpired(t0)
+	if err != nil || n != 2 {
+		t.Fatalf("reaped %d (%v), want 2", n, err)
+	}
+	for _, shortUrl := range []*ShortUrl{&expired, &usedUp, &live} {
+		got, err := store.getShortUrl(shortUrl.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (got != nil) != (shortUrl == &live) {
+			t.Errorf("%s: got %v after reaping", shortUrl.Url, got)
+		}
+	}
+
+	// each reaped ShortUrl leaves behind why it's gone, and it's Id is never given out again
+	tests := []struct {
+		shortUrl *ShortUrl
+		reason   string
+	}{
+		{&expired, expiredReason},
+		{&usedUp, usedUpReason},
+		{&live, ""},
+	}
+	m := newTestApiFor(store)
+	for _, test := range tests {
+		got, err := store.getTombstone(test.shortUrl.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.reason == "" {
+			if got != nil {
+				t.Errorf("%s: got %+v, want nothing", test.shortUrl.Url, got)
+			}
+			continue
+		}
+		if got == nil || got.Reason != test.reason || !got.Created.Equal(t0) {
+			t.Errorf("%s: got %+v, want %q", test.shortUrl.Url, got, test.reason)
+		}
+
+		reuse := ShortUrl{Url: "https://example.com/again"}
+		if _, err := store.createShortUrl(noLists, testIds, &reuse, test.shortUrl.Id, false); err != ErrSlugTaken {
+			t.Errorf("%s: reusing the Id got %v, want ErrSlugTaken", test.shortUrl.Url, err)
+		}
+
+		apiErr := ApiError{}
+		rec := apiRequest(t, m, "GET", "/api/v1/urls/"+test.shortUrl.Id, "", &apiErr)
+		if rec.Code != http.StatusGone || apiErr.Code != "gone" || apiErr.Message != test.reason {
+			t.Errorf("%s: GET got %d %+v", test.shortUrl.Url, rec.Code, apiErr)
+		}
+	}
+}
+
+func TestGone(t *testing.T) {
+	rec := httptest.NewRecorder()
+	gone(rec, newTestTemplates(t), "This link has expired.")
+
+	if rec.Code != http.StatusGone {
+		t.Errorf("got %d, want %d", rec.Code, http.StatusGone)
+	}
+	if !strings.Contains(rec.Body.String(), "This link has expired.") {
+		t.Errorf("reason not shown in %q", rec.Body.String())
+	}
+}
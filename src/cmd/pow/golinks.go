@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomiddleware/logger"
+)
+
+const searchMaxResults = 50
+
+var ErrInvalidTemplate = errors.New("Template must be a URL whose placeholders are only in the path, query or fragment")
+
+// placeholders are {1} to {9} for each path segment after the id, {*} for all of them, or {name} for the query
+// parameter of that name
+var placeholderRegExp = regexp.MustCompile(`\{(\*|[1-9]|[a-zA-Z_][a-zA-Z0-9_-]*)\}`)
+
+// expandTemplate fills in the placeholders in this template from the (still escaped) extra path segments and the
+// incoming query. Anything missing is left empty. Values are escaped for wherever they appear in the template, and
+// ErrInvalidEscape is returned if the extra path or query can't be unescaped.
+func expandTemplate(tmpl, extraPath, rawQuery string) (string, error) {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(extraPath, "/") {
+		if segment == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(segment)
+		if err != nil {
+			return "", ErrInvalidEscape
+		}
+		segments = append(segments, decoded)
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", ErrInvalidEscape
+	}
+
+	// anything after the '?' (or '#') is escaped as a query value, anything before as a path segment
+	queryStart := strings.IndexAny(tmpl, "?#")
+
+	expanded := ""
+	last := 0
+	for _, loc := range placeholderRegExp.FindAllStringSubmatchIndex(tmpl, -1) {
+		escape := url.PathEscape
+		if queryStart >= 0 && loc[0] > queryStart {
+			escape = url.QueryEscape
+		}
+
+		value := ""
+		name := tmpl[loc[2]:loc[3]]
+		if name == "*" {
+			escaped := make([]string, len(segments))
+			for i, segment := range segments {
+				escaped[i] = escape(segment)
+			}
+			value = strings.Join(escaped, "/")
+		} else if n, err := strconv.Atoi(name); err == nil {
+			if n <= len(segments) {
+				value = escape(segments[n-1])
+			}
+		} else {
+			value = escape(query.Get(name))
+		}
+
+		expanded += tmpl[last:loc[0]] + value
+		last = loc[1]
+	}
+
+	return expanded + tmpl[last:], nil
+}
+
+// validateTemplate checks this template makes a valid URL, and that it's placeholders can't change where it goes (only
+// the path, query and fragment can be filled in).
+func validateTemplate(tmpl string) (string, error) {
+	if tmpl == "" {
+		return "", nil
+	}
+	if !placeholderRegExp.MatchString(tmpl) {
+		return "", ErrInvalidTemplate
+	}
+
+	x, err := validateUrl(placeholderRegExp.ReplaceAllString(tmpl, "x"))
+	if err != nil {
+		return "", err
+	}
+	y, err := validateUrl(placeholderRegExp.ReplaceAllString(tmpl, "y"))
+	if err != nil {
+		return "", err
+	}
+	if x.Scheme != y.Scheme || x.Host != y.Host {
+		return "", ErrInvalidTemplate
+	}
+
+	return tmpl, nil
+}
+
+// searchMatch tells you how well this ShortUrl matches q (in lowercase): 2 if it's id, title (or any word of it),
+// destination host or any of it's tags start with q, 1 if one of them merely contains q, otherwise 0.
+func (s *ShortUrl) searchMatch(q string) int {
+	fields := []string{s.Id, s.Title}
+	fields = append(fields, strings.Fields(s.Title)...)
+	fields = append(fields, s.Tags...)
+
+	// where protected ShortUrls go is secret
+	if s.Password == "" {
+		if u, err := url.Parse(s.Url); err == nil {
+			fields = append(fields, strings.TrimPrefix(u.Hostname(), "www."))
+		}
+	}
+
+	match := 0
+	for _, field := range fields {
+		field = strings.ToLower(field)
+		if strings.HasPrefix(field, q) {
+			return 2
+		}
+		if strings.Contains(field, q) {
+			match = 1
+		}
+	}
+	return match
+}
+
+// searchShortUrls returns the ShortUrls which match q (see searchMatch), with those starting with q first. If a tag is
+// given, only ShortUrls with that tag are searched and q may be empty to get all of them.
+func (s *bucketStore) searchShortUrls(q, tag string) ([]*ShortUrl, error) {
+	q = strings.ToLower(q)
+	prefixed := make([]*ShortUrl, 0)
+	others := make([]*ShortUrl, 0)
+
+	err := s.view(func(tx storeTx) error {
+		check := func(k string, v []byte) error {
+			// we only need the best matches
+			if len(prefixed) >= searchMaxResults {
+				return errStop
+			}
+
+			// taken down ShortUrls can't be found
+			takedown, err := tx.get(takedownBucketNameStr, k)
+			if err != nil {
+				return err
+			}
+			if takedown != nil {
+				return nil
+			}
+
+			shortUrl := &ShortUrl{}
+			if err := json.Unmarshal(v, shortUrl); err != nil {
+				return err
+			}
+
+			match := 2
+			if q != "" {
+				match = shortUrl.searchMatch(q)
+			}
+			if match == 2 {
+				prefixed = append(prefixed, shortUrl)
+			} else if match == 1 && len(others) < searchMaxResults {
+				others = append(others, shortUrl)
+			}
+			return nil
+		}
+
+		if tag == "" {
+			return each(tx, urlBucketNameStr, "", check)
+		}
+
+		ids, err := taggedIdsTx(tx, tag)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			v, err := tx.get(urlBucketNameStr, id)
+			if err != nil {
+				return err
+			}
+			if v == nil {
+				continue
+			}
+			if err := check(id, v); err != nil {
+				if err == errStop {
+					return nil
+				}
+				return err
+			}
+		}
+		return nil
+	})
+
+	results := append(prefixed, others...)
+	if len(results) > searchMaxResults {
+		results = results[:searchMaxResults]
+	}
+	return results, err
+}
+
+// renderSearch shows the search results for q and/or tag, which is also what unknown keywords get.
+func renderSearch(w http.ResponseWriter, tmpl *template.Template, status int, store Store, baseUrl, q, tag string, unknown bool) {
+	results := make([]*ShortUrl, 0)
+	if q != "" || tag != "" {
+		var err error
+		results, err = store.searchShortUrls(q, tag)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+	}
+
+	// protected ShortUrls don't show where they go
+	for i, shortUrl := range results {
+		if shortUrl.Password != "" {
+			redacted := shortUrl.redacted()
+			results[i] = &redacted
+		}
+	}
+
+	data := struct {
+		BaseUrl  string
+		Query    string
+		Tag      string
+		Unknown  bool
+		CanClaim bool
+		Results  []*ShortUrl
+	}{
+		baseUrl,
+		q,
+		tag,
+		unknown,
+		unknown && validateSlug(q) == nil,
+		results,
+	}
+	renderStatus(w, tmpl, status, "search.html", data)
+}
+
+func searchGet(store Store, tmpl *template.Template, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := strings.TrimSpace(r.FormValue("q"))
+		tag := strings.ToLower(strings.TrimSpace(r.FormValue("tag")))
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("Query", q)
+		lgr.WithField("Tag", tag)
+		lgr.Print("search")
+
+		renderSearch(w, tmpl, http.StatusOK, store, baseUrl, q, tag, false)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl      string
+		extraPath string
+		rawQuery  string
+		want      string
+	}{
+		{"https://example.com/issues/{1}", "123", "", "https://example.com/issues/123"},
+		{"https://example.com/{2}/{1}", "a/b", "", "https://example.com/b/a"},
+		{"https://example.com/{1}/{2}", "a", "", "https://example.com/a/"},
+		{"https://example.com/src/{*}", "a/b%20c/", "", "https://example.com/src/a/b%20c"},
+		{"https://example.com/search?q={*}", "a/b c", "", "https://example.com/search?q=a/b+c"},
+		{"https://example.com/search?q={1}&lang={lang}", "go", "lang=en&x=1", "https://example.com/search?q=go&lang=en"},
+		{"https://example.com/{1}", "..%2F..%2Fadmin", "", "https://example.com/..%2F..%2Fadmin"},
+	}
+	for _, test := range tests {
+		got, err := expandTemplate(test.tmpl, test.extraPath, test.rawQuery)
+		if err != nil || got != test.want {
+			t.Errorf("expandTemplate(%q, %q, %q) = %q, %v, want %q", test.tmpl, test.extraPath, test.rawQuery, got, err, test.want)
+		}
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl string
+		err  error
+	}{
+		{"", nil},
+		{"https://example.com/issues/{1}", nil},
+		{"https://example.com/?q={q}#{2}", nil},
+		{"https://example.com/", ErrInvalidTemplate},
+		{"https://{1}.example.com/", ErrInvalidTemplate},
+		{"https://example.com{1}", ErrInvalidTemplate},
+		{"{1}://example.com/", ErrInvalidScheme},
+	}
+	for _, test := range tests {
+		if _, err := validateTemplate(test.tmpl); err != test.err {
+			t.Errorf("validateTemplate(%q): got %v, want %v", test.tmpl, err, test.err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := newTestStore(t)
+	links := []struct {
+		slug     string
+		shortUrl ShortUrl
+	}{
+		{"my-docs", ShortUrl{Url: "https://example.com/1"}},
+		{"docs", ShortUrl{Url: "https://example.com/2"}},
+		{"docs-secret", ShortUrl{Url: "https://example.com/3", Password: "secret"}},
+		{"docs-gone", ShortUrl{Url: "https://example.com/4"}},
+	}
+	for _, link := range links {
+		if _, err := store.createShortUrl(noLists, testIds, &link.shortUrl, link.slug, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.putTakedown(&Takedown{Id: "docs-gone", Reason: "Abuse.", Status: 410}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := store.searchShortUrls("DOCS", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, len(results))
+	for i, shortUrl := range results {
+		ids[i] = shortUrl.Id
+	}
+	if strings.Join(ids, ",") != "docs,docs-secret,my-docs" {
+		t.Errorf("got %v, want those starting with docs first and no taken down ones", ids)
+	}
+
+	tmpl := newTestTemplates(t)
+	m := newTestMux()
+	m.Get("/-/search", searchGet(store, tmpl, "https://pow.example"))
+	m.Get("/:id", func(w http.ResponseWriter, r *http.Request) {
+		renderSearch(w, tmpl, http.StatusNotFound, store, "https://pow.example", "new-docs", "", true)
+	})
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/-/search?q=docs", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "https://example.com/1") || strings.Contains(body, "https://example.com/3") {
+		t.Errorf("search: got %d %s", rec.Code, body)
+	}
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/new-docs", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "/new?slug=new-docs") {
+		t.Errorf("unknown keyword: got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"html/template"
 	"log"
 	"net/http"
@@ -35,12 +36,48 @@ func notFound(w http.ResponseWriter, r *http.Request) {
 	http.NotFound(w, r)
 }
 
+func gone(w http.ResponseWriter, tmpl *template.Template, reason string) {
+	data := struct {
+		Reason string
+	}{
+		reason,
+	}
+	renderStatus(w, tmpl, http.StatusGone, "gone.html", data)
+}
+
+func takenDown(w http.ResponseWriter, tmpl *template.Template, status int, reason string) {
+	data := struct {
+		Status int
+		Reason string
+	}{
+		status,
+		reason,
+	}
+	renderStatus(w, tmpl, status, "takedown.html", data)
+}
+
+func badRequest(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func forbidden(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
+
+func conflict(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), http.StatusConflict)
+}
+
 func internalServerError(w http.ResponseWriter, err error) {
 	log.Printf("Err: %s\n", err)
 	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
 func render(w http.ResponseWriter, tmpl *template.Template, tmplName string, data interface{}) {
+	renderStatus(w, tmpl, http.StatusOK, tmplName, data)
+}
+
+func renderStatus(w http.ResponseWriter, tmpl *template.Template, status int, tmplName string, data interface{}) {
 	buf := &bytes.Buffer{}
 	err := tmpl.ExecuteTemplate(buf, tmplName, data)
 	if err != nil {
@@ -48,5 +85,27 @@ func render(w http.ResponseWriter, tmpl *template.Template, tmplName string, dat
 		return
 	}
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
+
+func sendJson(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b)
+	w.Write([]byte("\n"))
+}
+
+func sendApiError(w http.ResponseWriter, status int, code string, err error) {
+	if status == http.StatusInternalServerError {
+		log.Printf("Err: %s\n", err)
+	}
+	sendJson(w, status, ApiError{Status: status, Code: code, Message: err.Error()})
+}
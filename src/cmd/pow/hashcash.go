@@ -0,0 +1,257 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const challengeTtl = 10 * time.Minute
+const hashcashWindow = 10 * time.Minute // how far back creations count towards the difficulty
+const hashcashVolumeStep = 20           // creations within the window before the difficulty goes up
+const hashcashMaxExtraBits = 8
+
+var (
+	ErrProofRequired       = errors.New("A proof of work is required, see /api/v1/challenge")
+	ErrProofInvalid        = errors.New("The proof of work is invalid, has expired or has already been used")
+	ErrInvalidHashcashBits = errors.New("POW_PROOF_OF_WORK must be a number of bits between 1 and 32")
+)
+
+// Challenge is a proof of work to be solved before creating a ShortUrl anonymously. The solution is any nonce where
+// the SHA-256 of "<Challenge>:<nonce>" starts with at least Bits zero bits.
+type Challenge struct {
+	Challenge string
+	Bits      int
+	ExpiresAt time.Time
+}
+
+// hashcash issues and checks proofs of work. The challenges are signed, so nothing needs to be kept about them until
+// they are used, after which they are remembered until they expire so they can't be used again. The difficulty goes
+// up by one bit each time the number of recent creations doubles past hashcashVolumeStep.
+//
+// A proof is only spent once the ShortUrl it was for has been created. Until then it's held (see proofHold), so that
+// nobody else can use it, but a request which fails for some other reason can be tried again with the same proof.
+type hashcash struct {
+	key  []byte
+	bits int // the difficulty when it's quiet
+	keys apiKeys
+
+	mu     sync.Mutex
+	recent []time.Time
+	used   map[string]time.Time
+}
+
+func parseHashcashBits(str string) (int, error) {
+	n, err := strconv.Atoi(str)
+	if err != nil || n < 1 || n > 32 {
+		return 0, ErrInvalidHashcashBits
+	}
+	return n, nil
+}
+
+// newHashcash returns a hashcash with this base difficulty, signing it's challenges with a key derived from secret.
+// Requests with one of these API keys don't need a proof.
+func newHashcash(secret []byte, bits int, keys apiKeys) *hashcash {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("hashcash"))
+	return &hashcash{
+		key:  mac.Sum(nil),
+		bits: bits,
+		keys: keys,
+		used: make(map[string]time.Time),
+	}
+}
+
+// prune forgets about anything which no longer matters at time t. The lock must be held.
+func (h *hashcash) prune(t time.Time) {
+	i := 0
+	for i < len(h.recent) && t.Sub(h.recent[i]) > hashcashWindow {
+		i++
+	}
+	h.recent = h.recent[i:]
+
+	for challenge, expiresAt := range h.used {
+		if t.After(expiresAt) {
+			delete(h.used, challenge)
+		}
+	}
+}
+
+// difficulty returns how many bits a new challenge needs at time t.
+func (h *hashcash) difficulty(t time.Time) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune(t)
+
+	extra := 0
+	for step := hashcashVolumeStep; len(h.recent) >= step && extra < hashcashMaxExtraBits; step *= 2 {
+		extra++
+	}
+	if h.bits+extra > 32 {
+		return 32
+	}
+	return h.bits + extra
+}
+
+func (h *hashcash) sign(payload string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newChallenge returns a new challenge at the current difficulty, or nil if proofs of work are turned off.
+func (h *hashcash) newChallenge(t time.Time) (*Challenge, error) {
+	if h == nil {
+		return nil, nil
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	expiresAt := t.Add(challengeTtl)
+	bits := h.difficulty(t)
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), bits, hex.EncodeToString(salt))
+	return &Challenge{
+		Challenge: payload + "." + h.sign(payload),
+		Bits:      bits,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// leadingZeroBits counts the zero bits at the start of this hash.
+func leadingZeroBits(sum []byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// verify checks this nonce solves this challenge at time t, and that the challenge hasn't been used (or held) before.
+// If so, it's held until it's either spent or released.
+func (h *hashcash) verify(challenge, nonce string, t time.Time) error {
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 || !hmac.Equal([]byte(parts[3]), []byte(h.sign(strings.Join(parts[:3], ".")))) {
+		return ErrProofInvalid
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrProofInvalid
+	}
+	expiresAt := time.Unix(expires, 0)
+	if t.After(expiresAt) {
+		return ErrProofInvalid
+	}
+
+	n, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrProofInvalid
+	}
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	if leadingZeroBits(sum[:]) < n {
+		return ErrProofInvalid
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prune(t)
+	if _, ok := h.used[challenge]; ok {
+		return ErrProofInvalid
+	}
+	h.used[challenge] = expiresAt
+	return nil
+}
+
+// proofHold is a verified proof of work which hasn't been spent yet. Callers should defer release() as soon as they
+// have one, then spend() it once the ShortUrl has been created. A nil proofHold (when no proof was needed) does
+// nothing.
+type proofHold struct {
+	h         *hashcash
+	challenge string
+	spent     bool
+}
+
+// spend counts this proof as a creation at time t, after which release does nothing.
+func (p *proofHold) spend(t time.Time) {
+	if p == nil || p.spent {
+		return
+	}
+	p.spent = true
+
+	p.h.mu.Lock()
+	defer p.h.mu.Unlock()
+	p.h.recent = append(p.h.recent, t)
+}
+
+// release lets this proof be used again if it wasn't spent.
+func (p *proofHold) release() {
+	if p == nil || p.spent {
+		return
+	}
+	p.spent = true
+
+	p.h.mu.Lock()
+	defer p.h.mu.Unlock()
+	delete(p.h.used, p.challenge)
+}
+
+// require checks the proof of work given with this request, which is either in the X-Pow-Challenge and X-Pow-Nonce
+// headers or the challenge and nonce form values, and holds it until it's spent or released. Requests with an API key
+// don't need one, and neither does anything if proofs of work are turned off, in which case the proofHold is nil.
+func (h *hashcash) require(r *http.Request, t time.Time) (*proofHold, error) {
+	if h == nil || h.keys.find(r) != "" {
+		return nil, nil
+	}
+
+	challenge, nonce := r.Header.Get("X-Pow-Challenge"), r.Header.Get("X-Pow-Nonce")
+	if challenge == "" {
+		challenge, nonce = r.FormValue("challenge"), r.FormValue("nonce")
+	}
+	if challenge == "" || nonce == "" {
+		return nil, ErrProofRequired
+	}
+	if err := h.verify(challenge, nonce, t); err != nil {
+		return nil, err
+	}
+	return &proofHold{h: h, challenge: challenge}, nil
+}
+
+// proofErrorCode is the API error code for this error from require().
+func proofErrorCode(err error) string {
+	if err == ErrProofRequired {
+		return "proof-required"
+	}
+	return "invalid-proof"
+}
+
+func apiNewChallenge(proof *hashcash) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if proof == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		challenge, err := proof.newChallenge(now())
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		sendJson(w, http.StatusOK, challenge)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solve finds a nonce for this challenge, just like static/s/js/hashcash.js.
+func solve(c *Challenge) string {
+	for nonce := 0; ; nonce++ {
+		sum := sha256.Sum256([]byte(c.Challenge + ":" + strconv.Itoa(nonce)))
+		if leadingZeroBits(sum[:]) >= c.Bits {
+			return strconv.Itoa(nonce)
+		}
+	}
+}
+
+func TestParseHashcashBits(t *testing.T) {
+	if n, err := parseHashcashBits("20"); n != 20 || err != nil {
+		t.Errorf("got %d, %v", n, err)
+	}
+	for _, str := range []string{"0", "33", "lots"} {
+		if _, err := parseHashcashBits(str); err != ErrInvalidHashcashBits {
+			t.Errorf("parseHashcashBits(%q): got %v, want ErrInvalidHashcashBits", str, err)
+		}
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		sum  []byte
+		want int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x3f}, 10},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, test := range tests {
+		if got := leadingZeroBits(test.sum); got != test.want {
+			t.Errorf("leadingZeroBits(%x) = %d, want %d", test.sum, got, test.want)
+		}
+	}
+}
+
+func TestHashcashVerify(t *testing.T) {
+	h := newHashcash([]byte("secret"), 4, nil)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	c, err := h.newChallenge(t0)
+	if err != nil || c.Bits != 4 || !c.ExpiresAt.Equal(t0.Add(challengeTtl)) {
+		t.Fatalf("got %+v, %v", c, err)
+	}
+	nonce := solve(c)
+
+	// a nonce which doesn't solve it, since with only 4 bits one in 16 do
+	wrong := nonce
+	for {
+		wrong += "x"
+		sum := sha256.Sum256([]byte(c.Challenge + ":" + wrong))
+		if leadingZeroBits(sum[:]) < c.Bits {
+			break
+		}
+	}
+
+	other, err := newHashcash([]byte("other"), 4, nil).newChallenge(t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(c.Challenge, ".")
+	easier := strings.Join([]string{parts[0], "1", parts[2], parts[3]}, ".")
+
+	tests := []struct {
+		name      string
+		challenge string
+		nonce     string
+		t         time.Time
+	}{
+		{"wrong nonce", c.Challenge, wrong, t0},
+		{"expired", c.Challenge, nonce, t0.Add(challengeTtl + time.Second)},
+		{"other key", other.Challenge, solve(other), t0},
+		{"easier", easier, nonce, t0},
+		{"garbage", "not.a.challenge", nonce, t0},
+	}
+	for _, test := range tests {
+		if err := h.verify(test.challenge, test.nonce, test.t); err != ErrProofInvalid {
+			t.Errorf("%s: got %v, want ErrProofInvalid", test.name, err)
+		}
+	}
+
+	if err := h.verify(c.Challenge, nonce, t0); err != nil {
+		t.Errorf("solved: got %v", err)
+	}
+	if err := h.verify(c.Challenge, nonce, t0); err != ErrProofInvalid {
+		t.Errorf("used again: got %v, want ErrProofInvalid", err)
+	}
+}
+
+func TestHashcashDifficulty(t *testing.T) {
+	h := newHashcash([]byte("secret"), 4, nil)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		recent int
+		want   int
+	}{
+		{0, 4},
+		{hashcashVolumeStep - 1, 4},
+		{hashcashVolumeStep, 5},
+		{hashcashVolumeStep * 2, 6},
+		{hashcashVolumeStep * 1000, 4 + hashcashMaxExtraBits},
+	}
+	for _, test := range tests {
+		h.recent = make([]time.Time, test.recent)
+		for i := range h.recent {
+			h.recent[i] = t0
+		}
+		if got := h.difficulty(t0); got != test.want {
+			t.Errorf("%d recent: got %d bits, want %d", test.recent, got, test.want)
+		}
+	}
+
+	// and back down once they're old enough
+	if got := h.difficulty(t0.Add(hashcashWindow + time.Second)); got != 4 {
+		t.Errorf("later: got %d bits, want 4", got)
+	}
+}
+
+func TestHashcashRequire(t *testing.T) {
+	h := newHashcash([]byte("secret"), 4, parseApiKeys("trusted, "))
+	t0 := now()
+
+	r := httptest.NewRequest("POST", "/", nil)
+	if _, err := h.require(r, t0); err != ErrProofRequired {
+		t.Errorf("nothing: got %v, want ErrProofRequired", err)
+	}
+
+	r.Header.Set("X-Api-Key", "trusted")
+	if hold, err := h.require(r, t0); hold != nil || err != nil {
+		t.Errorf("API key: got %v, %v", hold, err)
+	}
+
+	c, err := h.newChallenge(t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = httptest.NewRequest("POST", "/", strings.NewReader("challenge="+c.Challenge+"&nonce="+solve(c)))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if hold, err := h.require(r, t0); hold == nil || err != nil {
+		t.Errorf("form: got %v, %v", hold, err)
+	}
+
+	var off *hashcash
+	if hold, err := off.require(httptest.NewRequest("POST", "/", nil), t0); hold != nil || err != nil {
+		t.Errorf("off: got %v, %v", hold, err)
+	}
+}
+
+func TestProofHold(t *testing.T) {
+	h := newHashcash([]byte("secret"), 4, nil)
+	t0 := now()
+
+	c, err := h.newChallenge(t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := solve(c)
+	hold := func() (*proofHold, error) {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Pow-Challenge", c.Challenge)
+		r.Header.Set("X-Pow-Nonce", nonce)
+		return h.require(r, t0)
+	}
+
+	// held proofs can't be used by anyone else, but can be tried again once released
+	first, err := hold()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hold(); err != ErrProofInvalid {
+		t.Errorf("while held: got %v, want ErrProofInvalid", err)
+	}
+	first.release()
+	if len(h.recent) != 0 {
+		t.Errorf("released: got %d recent, want 0", len(h.recent))
+	}
+
+	// and only count towards the difficulty once spent, after which they're gone for good
+	second, err := hold()
+	if err != nil {
+		t.Fatalf("after release: got %v", err)
+	}
+	second.spend(t0)
+	second.release()
+	if len(h.recent) != 1 {
+		t.Errorf("spent: got %d recent, want 1", len(h.recent))
+	}
+	if _, err := hold(); err != ErrProofInvalid {
+		t.Errorf("after spend: got %v, want ErrProofInvalid", err)
+	}
+
+	// nil holds, for when no proof was needed, do nothing
+	var none *proofHold
+	none.spend(t0)
+	none.release()
+}
+
+func TestApiProof(t *testing.T) {
+	store := newTestStore(t)
+	h := newHashcash([]byte("secret"), 4, nil)
+	m := newTestMux()
+	m.Get("/api/v1/challenge", apiNewChallenge(h))
+	m.Post("/api/v1/urls", apiCreateUrl(store, noLists, testIds, "https://pow.example", nil, newMetaFetcher(store, nil), h))
+
+	apiErr := ApiError{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/"}`, &apiErr)
+	if rec.Code != http.StatusForbidden || apiErr.Code != "proof-required" {
+		t.Errorf("no proof: got %d %+v", rec.Code, apiErr)
+	}
+
+	c := Challenge{}
+	apiRequest(t, m, "GET", "/api/v1/challenge", "", &c)
+	nonce := solve(&c)
+	create := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/urls", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Pow-Challenge", c.Challenge)
+		req.Header.Set("X-Pow-Nonce", nonce)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a proof isn't spent on a request which fails, so it can be fixed and sent again
+	if rec := create(`{"Url":"ftp://example.com/"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid url: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := create(`{"Url":"https://example.com/"}`); rec.Code != http.StatusCreated {
+		t.Errorf("with proof: got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := create(`{"Url":"https://example.com/"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("proof used again: got %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	// no challenges are needed when they're turned off
+	rec = httptest.NewRecorder()
+	apiNewChallenge(nil)(rec, httptest.NewRequest("GET", "/api/v1/challenge", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("off: got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const healthTick = time.Minute
+const healthBatch = 20 // how many ShortUrls have their destinations checked each tick
+const healthUserAgent = "Mozilla/5.0 (compatible; pow-health/1.0)"
+
+// Health is the outcome of the last check of a ShortUrl's destination. A destination is Dead if it couldn't be
+// fetched or the response says it's gone, and Moved if it redirected to a different site.
+type Health struct {
+	Url       string // the destination checked
+	Status    int    `json:",omitempty"` // of the final response, or 0 if there wasn't one
+	LatencyMs int64
+	FinalUrl  string `json:",omitempty"` // where it ended up after any redirects, if different
+	Error     string `json:",omitempty"`
+	Dead      bool   `json:",omitempty"`
+	Moved     bool   `json:",omitempty"`
+	Failures  int    `json:",omitempty"` // how many checks in a row it's been dead
+	Checked   time.Time
+}
+
+// BrokenLink is a ShortUrl with a destination which is either dead or has moved, for the admin report.
+type BrokenLink struct {
+	Id     string
+	Health *Health
+}
+
+// isDeadStatus tells you whether this status means the destination has gone. Those which only mean we aren't allowed
+// to see it (such as 401, 403 and 429) don't count.
+func isDeadStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400
+}
+
+// sameSite tells you whether these two Urls are on the same host, ignoring any "www.".
+func sameSite(a, b *url.URL) bool {
+	return strings.TrimPrefix(a.Hostname(), "www.") == strings.TrimPrefix(b.Hostname(), "www.")
+}
+
+// checkedDestinations are all of the destinations of this ShortUrl which are checked: it's Url, and that of each rule,
+// variant and app store a deep link falls back to. A Template isn't, since where it goes depends on the visitor.
+func (s *ShortUrl) checkedDestinations() []string {
+	dests := []string{s.Url}
+	add := func(dest string) {
+		if dest != "" && !containsString(dests, dest) {
+			dests = append(dests, dest)
+		}
+	}
+	for _, rule := range s.Rules {
+		add(rule.Url)
+	}
+	for _, variant := range s.Variants {
+		add(variant.Url)
+	}
+	if s.DeepLink != nil {
+		add(s.DeepLink.IosStore)
+		add(s.DeepLink.AndroidStore)
+	}
+	return dests
+}
+
+// findHealth returns the Health of this destination from those of a ShortUrl, or nil if it hasn't been checked.
+func findHealth(healths []*Health, dest string) *Health {
+	for _, health := range healths {
+		if health.Url == dest {
+			return health
+		}
+	}
+	return nil
+}
+
+// isHealthDue tells you whether any destination of this ShortUrl hasn't been checked within interval at time t,
+// including any which have never been checked at all.
+func isHealthDue(shortUrl *ShortUrl, healths []*Health, interval time.Duration, t time.Time) bool {
+	for _, dest := range shortUrl.checkedDestinations() {
+		health := findHealth(healths, dest)
+		if health == nil || t.Sub(health.Checked) >= interval {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHealth checks this destination using this client, continuing the count of failures from prev (if it was for
+// the same destination). Servers which don't allow HEAD are tried again with GET.
+func checkHealth(client *http.Client, dest string, prev *Health, t time.Time) *Health {
+	health := &Health{Url: dest, Checked: t}
+
+	start := time.Now()
+	resp, err := healthRequest(client, "HEAD", dest)
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		resp, err = healthRequest(client, "GET", dest)
+	}
+	health.LatencyMs = time.Since(start).Milliseconds()
+
+	if err != nil {
+		health.Error = fetchError(err)
+		health.Dead = true
+	} else {
+		health.Status = resp.StatusCode
+		health.Dead = isDeadStatus(resp.StatusCode)
+		if final := resp.Request.URL.String(); final != dest {
+			health.FinalUrl = final
+			if u, err := url.Parse(dest); err == nil {
+				health.Moved = !sameSite(u, resp.Request.URL)
+			}
+		}
+	}
+
+	if health.Dead {
+		health.Failures = 1
+		if prev != nil && prev.Url == dest {
+			health.Failures = prev.Failures + 1
+		}
+	}
+
+	return health
+}
+
+// healthRequest makes a request without reading the body, since only the status matters.
+func healthRequest(client *http.Client, method, dest string) (*http.Response, error) {
+	req, err := http.NewRequest(method, dest, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", healthUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// getHealth returns the Health of each destination of this id which has been checked, or nil if none have yet.
+func (s *bucketStore) getHealth(id string) ([]*Health, error) {
+	var healths []*Health
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, healthBucketNameStr, id, &healths)
+	})
+	return healths, err
+}
+
+// putHealth saves the Health of each destination of this id, as long as the ShortUrl still exists.
+func (s *bucketStore) putHealth(id string, healths []*Health) error {
+	return s.update(func(tx storeTx) error {
+		v, err := tx.get(urlBucketNameStr, id)
+		if err != nil || v == nil {
+			return err
+		}
+		return putJson(tx, healthBucketNameStr, id, healths)
+	})
+}
+
+// findDueHealthChecks returns up to max ShortUrls (along with the last Health of their destinations, if any) with a
+// destination which hasn't been checked within interval at time t, or which is new since. Disabled, expired and taken
+// down ShortUrls are left alone.
+func (s *bucketStore) findDueHealthChecks(interval time.Duration, t time.Time, max int) ([]*ShortUrl, [][]*Health, error) {
+	shortUrls := make([]*ShortUrl, 0)
+	healths := make([][]*Health, 0)
+
+	err := s.view(func(tx storeTx) error {
+		return each(tx, urlBucketNameStr, "", func(k string, v []byte) error {
+			if len(shortUrls) == max {
+				return errStop
+			}
+
+			shortUrl := ShortUrl{}
+			if err := json.Unmarshal(v, &shortUrl); err != nil {
+				return err
+			}
+			takedown, err := tx.get(takedownBucketNameStr, k)
+			if err != nil {
+				return err
+			}
+			if shortUrl.Disabled || shortUrl.hasExpired(t) || takedown != nil {
+				return nil
+			}
+
+			var prev []*Health
+			if err := getJson(tx, healthBucketNameStr, shortUrl.Id, &prev); err != nil {
+				return err
+			}
+			if !isHealthDue(&shortUrl, prev, interval, t) {
+				return nil
+			}
+
+			shortUrls = append(shortUrls, &shortUrl)
+			healths = append(healths, prev)
+			return nil
+		})
+	})
+
+	return shortUrls, healths, err
+}
+
+// healthChecker regularly checks every destination of any ShortUrls which haven't been checked within interval, a few
+// ShortUrls at a time.
+func healthChecker(store Store, client *http.Client, interval time.Duration) {
+	ticker := time.NewTicker(healthTick)
+	for range ticker.C {
+		shortUrls, healths, err := store.findDueHealthChecks(interval, now(), healthBatch)
+		if err != nil {
+			log.Printf("health: %s\n", err)
+			continue
+		}
+
+		for i, shortUrl := range shortUrls {
+			checked := make([]*Health, 0)
+			for _, dest := range shortUrl.checkedDestinations() {
+				health := checkHealth(client, dest, findHealth(healths[i], dest), now())
+				if health.Dead || health.Moved {
+					log.Printf("health: %s is broken at %s (status=%d, final=%s, error=%s)\n", shortUrl.Id, dest, health.Status, health.FinalUrl, health.Error)
+				}
+				checked = append(checked, health)
+			}
+			if err := store.putHealth(shortUrl.Id, checked); err != nil {
+				log.Printf("health: %s\n", err)
+			}
+		}
+	}
+}
+
+// listBrokenLinks returns every destination of a ShortUrl which was dead or had moved when it was last checked, those
+// which have been dead the longest first.
+func (s *bucketStore) listBrokenLinks() ([]*BrokenLink, error) {
+	broken := make([]*BrokenLink, 0)
+
+	err := s.view(func(tx storeTx) error {
+		return each(tx, healthBucketNameStr, "", func(k string, v []byte) error {
+			healths := make([]*Health, 0)
+			if err := json.Unmarshal(v, &healths); err != nil {
+				return err
+			}
+
+			var shortUrl *ShortUrl
+			if err := getJson(tx, urlBucketNameStr, k, &shortUrl); err != nil {
+				return err
+			}
+			if shortUrl == nil {
+				return nil
+			}
+
+			// only those it can still go to
+			dests := shortUrl.checkedDestinations()
+			for _, health := range healths {
+				if (health.Dead || health.Moved) && containsString(dests, health.Url) {
+					broken = append(broken, &BrokenLink{Id: k, Health: health})
+				}
+			}
+			return nil
+		})
+	})
+
+	sort.SliceStable(broken, func(i, j int) bool {
+		return broken[i].Health.Failures > broken[j].Health.Failures
+	})
+	return broken, err
+}
+
+func adminListBrokenLinks(store Store) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		broken, err := store.listBrokenLinks()
+		if err != nil {
+			sendApiError(w, http.StatusInternalServerError, "internal", err)
+			return
+		}
+		sendJson(w, http.StatusOK, broken)
+	}
+}
+
+// adminBrokenLinksPage is the same report as adminListBrokenLinks, but for browsers.
+func adminBrokenLinksPage(store Store, tmpl *template.Template, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		broken, err := store.listBrokenLinks()
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+
+		data := struct {
+			BaseUrl string
+			Broken  []*BrokenLink
+		}{
+			baseUrl,
+			broken,
+		}
+		render(w, tmpl, "broken.html", data)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsDeadStatus(t *testing.T) {
+	for status, want := range map[int]bool{200: false, 301: false, 401: false, 403: false, 429: false, 404: true, 410: true, 500: true} {
+		if got := isDeadStatus(status); got != want {
+			t.Errorf("isDeadStatus(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/get-only":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/private":
+			w.WriteHeader(http.StatusForbidden)
+		case "/moved":
+			// the same server, but under another name
+			http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/ok", http.StatusMovedPermanently)
+		case "/renamed":
+			http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+		}
+	}))
+	defer srv.Close()
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		path   string
+		status int
+		dead   bool
+		moved  bool
+	}{
+		{"/ok", 200, false, false},
+		{"/get-only", 200, false, false},
+		{"/gone", 410, true, false},
+		{"/private", 403, false, false},
+		{"/moved", 200, false, true},
+		{"/renamed", 200, false, false},
+	}
+	for _, test := range tests {
+		health := checkHealth(srv.Client(), srv.URL+test.path, nil, t0)
+		if health.Status != test.status || health.Dead != test.dead || health.Moved != test.moved {
+			t.Errorf("%s: got %+v", test.path, health)
+		}
+	}
+
+	// failures carry on counting, but only for the same destination
+	prev := &Health{Url: srv.URL + "/gone", Dead: true, Failures: 2}
+	if health := checkHealth(srv.Client(), srv.URL+"/gone", prev, t0); health.Failures != 3 {
+		t.Errorf("again: got %d failures, want 3", health.Failures)
+	}
+	prev.Url = srv.URL + "/other"
+	if health := checkHealth(srv.Client(), srv.URL+"/gone", prev, t0); health.Failures != 1 {
+		t.Errorf("changed: got %d failures, want 1", health.Failures)
+	}
+
+	health := checkHealth(newDestinationClient(), srv.URL+"/ok", nil, t0)
+	if !health.Dead || health.Error != ErrDestinationNotPublic.Error() {
+		t.Errorf("destination client: got %+v", health)
+	}
+}
+
+func TestBrokenLinks(t *testing.T) {
+	store := newTestStore(t)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for _, id := range []string{"fine", "dead", "moved", "off", "variant"} {
+		shortUrl := ShortUrl{Url: "https://example.com/" + id, Disabled: id == "off"}
+		if id == "variant" {
+			shortUrl.Variants = []Variant{{Name: "A", Url: "https://example.com/a", Weight: 1}}
+			shortUrl.DeepLink = &DeepLink{App: "myapp://x", IosStore: "https://apps.example/app"}
+		}
+		if _, err := store.createShortUrl(noLists, testIds, &shortUrl, id, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, _, err := store.findDueHealthChecks(time.Hour, t0, 10)
+	if err != nil || len(due) != 4 {
+		t.Fatalf("got %d due (%v), want 4", len(due), err)
+	}
+
+	healths := map[string][]*Health{
+		"fine":  {{Url: "https://example.com/fine", Status: 200, Checked: t0}},
+		"dead":  {{Url: "https://example.com/dead", Status: 404, Dead: true, Failures: 3, Checked: t0}},
+		"moved": {{Url: "https://example.com/moved", Status: 200, Moved: true, Checked: t0.Add(-2 * time.Hour)}},
+		"variant": {
+			{Url: "https://example.com/variant", Status: 200, Checked: t0},
+			{Url: "https://example.com/a", Status: 410, Dead: true, Failures: 1, Checked: t0},
+		},
+	}
+	for id, health := range healths {
+		if err := store.putHealth(id, health); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// every destination needs checking, including those of variants and deep links
+	due, _, err = store.findDueHealthChecks(time.Hour, t0, 10)
+	if err != nil || len(due) != 2 || due[0].Id != "moved" || due[1].Id != "variant" {
+		t.Fatalf("after checks: got %v (%v), want moved and variant", due, err)
+	}
+	if dests := due[1].checkedDestinations(); len(dests) != 3 || dests[2] != "https://apps.example/app" {
+		t.Errorf("destinations: got %v", dests)
+	}
+
+	broken, err := store.listBrokenLinks()
+	if err != nil || len(broken) != 3 || broken[0].Id != "dead" || broken[1].Health.Url != "https://example.com/a" || broken[2].Id != "moved" {
+		t.Errorf("got %+v (%v)", broken, err)
+	}
+
+	// a new destination hasn't been checked, so isn't broken yet
+	_, err = store.updateShortUrl(noLists, "dead", func(shortUrl *ShortUrl) error {
+		return changeDestination(shortUrl, "https://example.com/new", t0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if broken, err := store.listBrokenLinks(); err != nil || len(broken) != 2 {
+		t.Errorf("after change: got %+v (%v)", broken, err)
+	}
+}
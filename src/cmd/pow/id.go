@@ -1,22 +1,212 @@
 package main
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/chilts/sid"
 )
 
-const idChars string = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-const idCharLen = len(idChars)
+const idDefaultLen = 6
+const idMinLen = 4
+const idMaxLen = 32
+const idGrowAfter = 5 // collisions in a row before an ID gets longer
+const idBase62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// the alphabets which can be chosen by name in POW_ID_ALPHABET
+var idAlphabets = map[string]string{
+	"letters":       "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz",
+	"alphanumeric":  "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	"no-lookalikes": "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz123456789",
+	"lowercase":     "abcdefghijklmnopqrstuvwxyz0123456789",
+}
+
+// words which mustn't appear in a generated ID, checked after undoing any numbers standing in for letters
+var idProfanities = []string{
+	"anal", "anus", "arse", "ass", "bitch", "boob", "butt", "cock", "coon", "crap", "cum", "cunt", "dick", "dyke",
+	"fag", "fuck", "gay", "jizz", "kkk", "nazi", "nig", "penis", "piss", "poo", "porn", "pube", "puss", "rape",
+	"sex", "shit", "slag", "slut", "spic", "tit", "turd", "twat", "vag", "wank", "whore",
+}
+
+var idLeetReplacer = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b")
+
+var (
+	ErrInvalidIdAlphabet = errors.New("POW_ID_ALPHABET must be letters, alphanumeric, no-lookalikes, lowercase, or at least 2 different letters and numbers")
+	ErrInvalidIdLength   = errors.New("POW_ID_LENGTH must be a number between 4 and 32")
+	ErrInvalidIdStrategy = errors.New("POW_ID_STRATEGY must be random, counter or sid")
+)
+
+// idGenerator makes the IDs of new ShortUrls which aren't given a slug. Each ID is tried in turn until one isn't
+// already in use, where collisions is how many have been tried for this ShortUrl so far. Since it only decides new
+// IDs, existing ShortUrls keep working whichever is used.
+type idGenerator interface {
+	generate(tx storeTx, collisions int) (string, error)
+}
+
+// newIdGenerator returns the generator for this strategy, which is one of "random" (the default), "counter" or "sid".
+func newIdGenerator(strategy, alphabet string, length int) (idGenerator, error) {
+	switch strategy {
+	case "", "random":
+		return newRandomIds(alphabet, length), nil
+	case "counter":
+		return counterIds{}, nil
+	case "sid":
+		return sidIds{}, nil
+	}
+	return nil, ErrInvalidIdStrategy
+}
+
+// isUsableId tells you whether this ID can be given out, since it mustn't be offensive or one of the reservedSlugs.
+func isUsableId(id string) bool {
+	return !isProfane(id) && !reservedSlugs[strings.ToLower(id)]
+}
+
+// randomIds are the default, made of random characters from an alphabet. If too many in a row are already taken, the
+// rest tried for that ShortUrl get a character longer.
+type randomIds struct {
+	alphabet string
+	length   int
+}
+
+// parseIdAlphabet returns either the named alphabet or the characters given, which must be letters or numbers.
+func parseIdAlphabet(str string) (string, error) {
+	if str == "" {
+		return idAlphabets["letters"], nil
+	}
+	if alphabet, ok := idAlphabets[str]; ok {
+		return alphabet, nil
+	}
+
+	seen := make(map[rune]bool)
+	for _, c := range str {
+		if !strings.ContainsRune(idAlphabets["alphanumeric"], c) || seen[c] {
+			return "", ErrInvalidIdAlphabet
+		}
+		seen[c] = true
+	}
+	if len(seen) < 2 {
+		return "", ErrInvalidIdAlphabet
+	}
+	return str, nil
+}
+
+func parseIdLength(str string) (int, error) {
+	if str == "" {
+		return idDefaultLen, nil
+	}
+	n, err := strconv.Atoi(str)
+	if err != nil || n < idMinLen || n > idMaxLen {
+		return 0, ErrInvalidIdLength
+	}
+	return n, nil
+}
+
+func newRandomIds(alphabet string, length int) *randomIds {
+	return &randomIds{
+		alphabet: alphabet,
+		length:   length,
+	}
+}
+
+func (g *randomIds) generate(tx storeTx, collisions int) (string, error) {
+	length := g.grow(collisions)
+	for {
+		id, err := randomString(g.alphabet, length)
+		if err != nil {
+			return "", err
+		}
+		if isUsableId(id) {
+			return id, nil
+		}
+	}
+}
+
+// grow returns the length of the next ID to try after this many collisions, which is a character longer for every
+// idGrowAfter of them. Only this ShortUrl's IDs get longer, so one unlucky run doesn't make every ID from then on
+// longer too.
+func (g *randomIds) grow(collisions int) int {
+	length := g.length + collisions/idGrowAfter
+	if length > idMaxLen {
+		length = idMaxLen
+	}
+	if collisions > 0 && collisions%idGrowAfter == 0 {
+		log.Printf("id: %d collisions in a row, trying %d characters\n", collisions, length)
+	}
+	return length
+}
+
+// counterIds are the base 62 of a sequence kept by the url bucket, so they're as short as possible but also easy to
+// guess. Any numbers whose ID isn't usable are skipped.
+type counterIds struct{}
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+func (counterIds) generate(tx storeTx, collisions int) (string, error) {
+	for {
+		n, err := tx.nextSequence(urlBucketNameStr)
+		if err != nil {
+			return "", err
+		}
+		if id := base62(n); isUsableId(id) {
+			return id, nil
+		}
+	}
+}
+
+func base62(n uint64) string {
+	if n == 0 {
+		return idBase62[:1]
+	}
+	id := make([]byte, 0, 11)
+	for ; n > 0; n /= 62 {
+		id = append(id, idBase62[n%62])
+	}
+	// the digits came out backwards
+	for i, j := 0, len(id)-1; i < j; i, j = i+1, j-1 {
+		id[i], id[j] = id[j], id[i]
+	}
+	return string(id)
+}
+
+// sidIds are made by github.com/chilts/sid, which start with the time they were made so they sort in the order the
+// ShortUrls were created, at the cost of being 23 characters long.
+type sidIds struct{}
+
+func (sidIds) generate(tx storeTx, collisions int) (string, error) {
+	for {
+		if id := sid.Id(); isUsableId(id) {
+			return id, nil
+		}
+	}
+}
+
+// randomString returns length characters chosen uniformly from alphabet, which must be at most 256 bytes.
+func randomString(alphabet string, length int) (string, error) {
+	// bytes at or over max would make the first few characters more likely, so they're skipped
+	max := 256 - 256%len(alphabet)
+	id := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(id) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < max && len(id) < length {
+				id = append(id, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(id), nil
 }
 
-func Id(len int) string {
-	str := ""
-	for i := 0; i < len; i++ {
-		r := rand.Intn(idCharLen)
-		str = str + string(idChars[r])
+// isProfane tells you whether this ID contains any of the idProfanities.
+func isProfane(id string) bool {
+	id = idLeetReplacer.Replace(strings.ToLower(id))
+	for _, word := range idProfanities {
+		if strings.Contains(id, word) {
+			return true
+		}
 	}
-	return str
+	return false
 }
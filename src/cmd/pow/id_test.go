@@ -0,0 +1,205 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// fixedIds gives out these IDs in turn, whatever the number of collisions.
+type fixedIds struct {
+	ids []string
+}
+
+func (g *fixedIds) generate(tx storeTx, collisions int) (string, error) {
+	id := g.ids[0]
+	g.ids = g.ids[1:]
+	return id, nil
+}
+
+func TestParseIdAlphabet(t *testing.T) {
+	tests := []struct {
+		str  string
+		want string
+		err  error
+	}{
+		{"", idAlphabets["letters"], nil},
+		{"no-lookalikes", idAlphabets["no-lookalikes"], nil},
+		{"abc123", "abc123", nil},
+		{"a", "", ErrInvalidIdAlphabet},
+		{"aab", "", ErrInvalidIdAlphabet},
+		{"ab-", "", ErrInvalidIdAlphabet},
+		{"åb", "", ErrInvalidIdAlphabet},
+	}
+	for _, test := range tests {
+		if got, err := parseIdAlphabet(test.str); got != test.want || err != test.err {
+			t.Errorf("parseIdAlphabet(%q) = %q, %v, want %q, %v", test.str, got, err, test.want, test.err)
+		}
+	}
+
+	// none of the named ones have anything which could be mistaken for something else
+	for _, c := range "0OIl" {
+		if strings.ContainsRune(idAlphabets["no-lookalikes"], c) {
+			t.Errorf("no-lookalikes has %c", c)
+		}
+	}
+}
+
+func TestParseIdLength(t *testing.T) {
+	for str, want := range map[string]int{"": idDefaultLen, "4": 4, "32": 32} {
+		if got, err := parseIdLength(str); got != want || err != nil {
+			t.Errorf("parseIdLength(%q) = %d, %v, want %d", str, got, err, want)
+		}
+	}
+	for _, str := range []string{"3", "33", "six"} {
+		if _, err := parseIdLength(str); err != ErrInvalidIdLength {
+			t.Errorf("parseIdLength(%q): got %v, want ErrInvalidIdLength", str, err)
+		}
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	counts := make(map[rune]int)
+	for i := 0; i < 1000; i++ {
+		id, err := randomString("abc", 9)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 9 {
+			t.Fatalf("got %q, want 9 characters", id)
+		}
+		for _, c := range id {
+			counts[c]++
+		}
+	}
+
+	// 3000 of each, give or take
+	if len(counts) != 3 {
+		t.Errorf("got %v, want only a, b and c", counts)
+	}
+	for c, n := range counts {
+		if n < 2700 || n > 3300 {
+			t.Errorf("got %c %d times, want about 3000", c, n)
+		}
+	}
+}
+
+func TestIsProfane(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"xShitx", true},
+		{"5h1t", true},
+		{"a55", true},
+		{"abcDEF", false},
+		{"Gbq7Rz", false},
+	}
+	for _, test := range tests {
+		if got := isProfane(test.id); got != test.want {
+			t.Errorf("isProfane(%q) = %v, want %v", test.id, got, test.want)
+		}
+	}
+}
+
+func TestRandomIds(t *testing.T) {
+	// most IDs from these are profane, or reserved
+	for _, alphabet := range []string{"as", "aip"} {
+		g := newRandomIds(alphabet, 3)
+		for i := 0; i < 100; i++ {
+			id, err := g.generate(nil, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(id) != 3 || isProfane(id) || reservedSlugs[id] {
+				t.Errorf("%s: got %q", alphabet, id)
+			}
+		}
+	}
+
+	g := newRandomIds("abc", idDefaultLen)
+	if id, _ := g.generate(nil, idGrowAfter*2); len(id) != idDefaultLen+2 {
+		t.Errorf("after %d collisions: got %q, want %d characters", idGrowAfter*2, id, idDefaultLen+2)
+	}
+	if id, _ := g.generate(nil, idGrowAfter-1); len(id) != idDefaultLen {
+		t.Errorf("after %d collisions: got %q, want %d characters", idGrowAfter-1, id, idDefaultLen)
+	}
+
+	// and the next ShortUrl starts from the usual length again
+	if id, _ := g.generate(nil, 0); len(id) != idDefaultLen {
+		t.Errorf("after growing: got %q, want %d characters", id, idDefaultLen)
+	}
+
+	g = newRandomIds("abc", idMaxLen)
+	if got := g.grow(idGrowAfter); got != idMaxLen {
+		t.Errorf("at the longest: got %d, want %d", got, idMaxLen)
+	}
+}
+
+func TestCreateCollisions(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/1"}, "taken", false); err != nil {
+		t.Fatal(err)
+	}
+
+	shortUrl := ShortUrl{Url: "https://example.com/2"}
+	ids := &fixedIds{ids: []string{"taken", "taken", "fresh"}}
+	if _, err := store.createShortUrl(noLists, ids, &shortUrl, "", false); err != nil {
+		t.Fatal(err)
+	}
+	if shortUrl.Id != "fresh" || len(ids.ids) != 0 {
+		t.Errorf("got %s, want fresh", shortUrl.Id)
+	}
+}
+
+func TestNewIdGenerator(t *testing.T) {
+	for _, strategy := range []string{"", "random", "counter", "sid"} {
+		if _, err := newIdGenerator(strategy, "abc", idDefaultLen); err != nil {
+			t.Errorf("%q: got %v", strategy, err)
+		}
+	}
+	if _, err := newIdGenerator("uuid", "abc", idDefaultLen); err != ErrInvalidIdStrategy {
+		t.Errorf("got %v, want ErrInvalidIdStrategy", err)
+	}
+}
+
+func TestBase62(t *testing.T) {
+	for n, want := range map[uint64]string{0: "0", 9: "9", 10: "A", 61: "z", 62: "10", 3843: "zz", 3844: "100"} {
+		if got := base62(n); got != want {
+			t.Errorf("base62(%d) = %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestCounterIds(t *testing.T) {
+	store := newTestStore(t)
+
+	got := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		shortUrl := ShortUrl{Url: "https://example.com/" + strings.Repeat("x", i)}
+		if _, err := store.createShortUrl(noLists, counterIds{}, &shortUrl, "", false); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, shortUrl.Id)
+	}
+	if strings.Join(got, ",") != "1,2,3" {
+		t.Errorf("got %v, want 1, 2 and 3", got)
+	}
+}
+
+func TestSidIds(t *testing.T) {
+	first, err := sidIds{}.generate(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sidIds{}.generate(nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second || first > second {
+		t.Errorf("got %s then %s, want them in order", first, second)
+	}
+	if url.PathEscape(first) != first {
+		t.Errorf("%s needs escaping", first)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomiddleware/logger"
+	"github.com/gomiddleware/mux"
+)
+
+// manageCookieTtl is how long a browser is remembered as the owner of a ShortUrl after visiting it's ManageLink.
+const manageCookieTtl = 7 * 24 * time.Hour
+
+var (
+	ErrNotOwner       = errors.New("A valid management token is required for this Short URL")
+	ErrUnknownAction  = errors.New("Unknown action")
+	ErrNothingChanged = errors.New("Nothing to change")
+)
+
+// newOwnerToken generates a new management token for this ShortUrl, which is returned so it can be given to the
+// creator. Only a hash of the token is kept.
+func newOwnerToken(shortUrl *ShortUrl) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	token := hex.EncodeToString(b)
+	shortUrl.Owner = hashOwnerToken(token)
+	return token, nil
+}
+
+func hashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isOwner tells you whether this token is the management token for this ShortUrl. ShortUrls created before management
+// tokens existed have no owner and therefore can't be managed.
+func isOwner(shortUrl *ShortUrl, token string) bool {
+	if shortUrl.Owner == "" || token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(shortUrl.Owner), []byte(hashOwnerToken(token))) == 1
+}
+
+// bearerToken returns the token from the "Authorization: Bearer <token>" header, if any.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+// changeDestination validates and sets the new Url for this ShortUrl, keeping the previous one in it's History.
+func changeDestination(shortUrl *ShortUrl, str string, t time.Time) error {
+	u, err := validateUrl(str)
+	if err != nil {
+		return &FieldError{"url", err}
+	}
+	if u.String() == shortUrl.Url {
+		return nil
+	}
+
+	shortUrl.History = append(shortUrl.History, Destination{Url: shortUrl.Url, Until: t})
+	shortUrl.Url = u.String()
+	shortUrl.Updated = t
+	return nil
+}
+
+// manageLink is the link given to the creator of a ShortUrl. Visiting it swaps the token for a cookie (see
+// manageEnter) so it's only ever in the address bar for one request.
+func manageLink(baseUrl, id, token string) string {
+	return baseUrl + "/-/manage/" + id + "/" + token
+}
+
+// managePath is where the management page is once the token is in a cookie.
+func managePath(id string) string {
+	return "/-/manage/" + id
+}
+
+// manageCookies remembers the management token for each ShortUrl a browser has visited the ManageLink of, in a signed
+// cookie per ShortUrl which is only sent to it's management page.
+type manageCookies struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func newManageCookies(secret []byte, ttl time.Duration) *manageCookies {
+	return &manageCookies{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+func (c *manageCookies) cookieName(id string) string {
+	return "pow-manage-" + id
+}
+
+func (c *manageCookies) sign(id, expiry, token string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte("manage." + id + "." + expiry + "." + token))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// set sets the cookie holding this token for this ShortUrl.
+func (c *manageCookies) set(w http.ResponseWriter, id, token string, t time.Time) {
+	expires := t.Add(c.ttl)
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName(id),
+		Value:    expiry + "." + token + "." + c.sign(id, expiry, token),
+		Path:     managePath(id),
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// token returns the token from this request's cookie for this ShortUrl, or "" if it doesn't have a valid (and
+// unexpired) one.
+func (c *manageCookies) token(r *http.Request, id string, t time.Time) string {
+	cookie, err := r.Cookie(c.cookieName(id))
+	if err != nil {
+		return ""
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || t.Unix() >= expiry {
+		return ""
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(c.sign(id, parts[0], parts[1]))) {
+		return ""
+	}
+	return parts[1]
+}
+
+// noReferrer stops the browser sending the address of a management page (or anything on it) to anywhere else.
+func noReferrer(w http.ResponseWriter) {
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func renderManage(w http.ResponseWriter, tmpl *template.Template, status int, baseUrl string, shortUrl *ShortUrl, token, msg string) {
+	data := struct {
+		BaseUrl    string
+		ShortUrl   *ShortUrl
+		ManageLink string
+		Action     string
+		Error      string
+	}{
+		baseUrl,
+		shortUrl,
+		manageLink(baseUrl, shortUrl.Id, token),
+		managePath(shortUrl.Id),
+		msg,
+	}
+	noReferrer(w)
+	renderStatus(w, tmpl, status, "manage.html", data)
+}
+
+// manageEnter takes the token from the ManageLink and puts it into a cookie, then redirects to the management page
+// without it.
+func manageEnter(store Store, cookies *manageCookies) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vals := mux.Vals(r)
+		noReferrer(w)
+
+		shortUrl, err := store.getShortUrl(vals["id"])
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if shortUrl == nil || !isOwner(shortUrl, vals["token"]) {
+			notFound(w, r)
+			return
+		}
+
+		cookies.set(w, shortUrl.Id, vals["token"], now())
+		http.Redirect(w, r, managePath(shortUrl.Id), http.StatusSeeOther)
+	}
+}
+
+func manageGet(store Store, cookies *manageCookies, tmpl *template.Template, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+		token := cookies.token(r, id, now())
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if shortUrl == nil || !isOwner(shortUrl, token) {
+			notFound(w, r)
+			return
+		}
+
+		renderManage(w, tmpl, http.StatusOK, baseUrl, shortUrl, token, "")
+	}
+}
+
+func managePost(store Store, lists *blocklists, cookies *manageCookies, tmpl *template.Template, baseUrl string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+		token := cookies.token(r, id, now())
+		action := r.FormValue("action")
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("ShortUrlId", id)
+		lgr.WithField("Action", action)
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if shortUrl == nil || !isOwner(shortUrl, token) {
+			notFound(w, r)
+			return
+		}
+
+		if action == "delete" {
+			err = store.deleteShortUrl(id, now())
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+			lgr.Print("short-url-deleted")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		updated, err := store.updateShortUrl(lists, id, func(shortUrl *ShortUrl) error {
+			t := now()
+			switch action {
+			case "update":
+				return changeDestination(shortUrl, r.FormValue("url"), t)
+			case "details":
+				title, err := validateTitle(r.FormValue("title"))
+				if err != nil {
+					return &FieldError{"title", err}
+				}
+				if len(r.FormValue("notes")) > notesMaxLen {
+					return &FieldError{"notes", ErrNotesTooLong}
+				}
+				tags, err := validateTags(parseTags(r.FormValue("tags")))
+				if err != nil {
+					return &FieldError{"tags", err}
+				}
+				shortUrl.Title = title
+				shortUrl.Notes = r.FormValue("notes")
+				shortUrl.Tags = tags
+			case "template":
+				template, err := validateTemplate(r.FormValue("template"))
+				if err != nil {
+					return &FieldError{"template", err}
+				}
+				shortUrl.Template = template
+			case "redirect":
+				// an empty status goes back to the instance default
+				status := 0
+				if str := r.FormValue("redirect-status"); str != "" {
+					var err error
+					status, err = parseRedirectStatus(str)
+					if err != nil {
+						return &FieldError{"redirect-status", err}
+					}
+				}
+				shortUrl.RedirectStatus = status
+
+				if !isQueryPassthrough(r.FormValue("query-passthrough")) {
+					return &FieldError{"query-passthrough", ErrInvalidQueryPassthrough}
+				}
+				shortUrl.QueryPassthrough = r.FormValue("query-passthrough")
+				shortUrl.PathPassthrough = r.FormValue("path-passthrough") != ""
+			case "campaign":
+				campaign, err := validateCampaign(campaignFromForm(r))
+				if err != nil {
+					return &FieldError{"campaign", err}
+				}
+				shortUrl.Campaign = campaign
+			case "disable":
+				shortUrl.Disabled = true
+			case "enable":
+				shortUrl.Disabled = false
+			default:
+				return ErrUnknownAction
+			}
+			shortUrl.Updated = t
+			return nil
+		})
+		if err == ErrUnknownAction {
+			badRequest(w, err)
+			return
+		}
+		if _, ok := err.(*FieldError); ok || err == ErrDestinationBlocked {
+			renderManage(w, tmpl, http.StatusBadRequest, baseUrl, shortUrl, token, err.Error())
+			return
+		}
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if updated == nil {
+			notFound(w, r)
+			return
+		}
+
+		lgr.Print("short-url-updated")
+		http.Redirect(w, r, managePath(id), http.StatusSeeOther)
+	}
+}
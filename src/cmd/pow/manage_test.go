@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOwnerToken(t *testing.T) {
+	shortUrl := ShortUrl{}
+	token, err := newOwnerToken(&shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shortUrl.Owner == "" || shortUrl.Owner == token {
+		t.Errorf("owner is %q, want a hash of the token", shortUrl.Owner)
+	}
+	if !isOwner(&shortUrl, token) {
+		t.Error("token refused")
+	}
+	if isOwner(&shortUrl, token+"0") || isOwner(&shortUrl, "") {
+		t.Error("wrong token accepted")
+	}
+	if isOwner(&ShortUrl{}, "") {
+		t.Error("ShortUrl without an owner can be managed")
+	}
+}
+
+func TestChangeDestination(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	shortUrl := ShortUrl{Url: "https://example.com/old"}
+
+	if err := changeDestination(&shortUrl, "https://example.com/old", t0); err != nil || shortUrl.History != nil {
+		t.Errorf("same Url: got %v and History %v", err, shortUrl.History)
+	}
+	if err := changeDestination(&shortUrl, "javascript:alert(1)", t0); err == nil {
+		t.Error("invalid Url accepted")
+	}
+
+	if err := changeDestination(&shortUrl, "https://example.com/new", t0); err != nil {
+		t.Fatal(err)
+	}
+	if shortUrl.Url != "https://example.com/new" || len(shortUrl.History) != 1 || shortUrl.History[0].Url != "https://example.com/old" {
+		t.Errorf("got %+v", shortUrl)
+	}
+}
+
+func TestApiUpdateAndDelete(t *testing.T) {
+	m := newTestApi(t)
+
+	created := ApiUrl{}
+	apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/old"}`, &created)
+	if created.Token == "" || created.ManageLink != "https://pow.example/-/manage/"+created.Id+"/"+created.Token {
+		t.Fatalf("create: got token %q and manage link %q", created.Token, created.ManageLink)
+	}
+	path := "/api/v1/urls/" + created.Id
+
+	tests := []struct {
+		token  string
+		method string
+		body   string
+		status int
+	}{
+		{"", "PATCH", `{"Url":"https://example.com/new"}`, http.StatusForbidden},
+		{"nope", "PATCH", `{"Url":"https://example.com/new"}`, http.StatusForbidden},
+		{created.Token, "PATCH", `{}`, http.StatusBadRequest},
+		{created.Token, "PATCH", `{"Url":"ftp://example.com/"}`, http.StatusBadRequest},
+		{created.Token, "PATCH", `{"Url":"https://example.com/new","Disabled":true}`, http.StatusOK},
+		{"nope", "DELETE", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		rec := apiRequestAs(t, m, test.token, test.method, path, test.body, nil)
+		if rec.Code != test.status {
+			t.Errorf("%s %s %s: got %d, want %d", test.method, path, test.body, rec.Code, test.status)
+		}
+	}
+
+	got := ApiUrl{}
+	apiRequest(t, m, "GET", path, "", &got)
+	if got.Url != "https://example.com/new" || !got.Disabled || len(got.History) != 1 || got.Owner != "" || got.Token != "" {
+		t.Errorf("after update: got %+v", got)
+	}
+
+	if rec := apiRequestAs(t, m, created.Token, "DELETE", path, "", nil); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: got %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	// it leaves a tombstone behind, so the Id is never given to anyone else
+	apiErr := ApiError{}
+	if rec := apiRequest(t, m, "GET", path, "", &apiErr); rec.Code != http.StatusGone || apiErr.Code != "gone" || apiErr.Message != deletedReason {
+		t.Errorf("get after delete: got %d %+v, want %d", rec.Code, apiErr, http.StatusGone)
+	}
+	body := `{"Url":"https://example.com/other","Slug":"` + created.Id + `"}`
+	if rec := apiRequest(t, m, "POST", "/api/v1/urls", body, nil); rec.Code != http.StatusConflict {
+		t.Errorf("reusing the Id after delete: got %d, want %d", rec.Code, http.StatusConflict)
+	}
+}
+
+func TestManagePage(t *testing.T) {
+	store := newTestStore(t)
+	tmpl := newTestTemplates(t)
+	m := newTestMux()
+	cookies := newManageCookies([]byte("secret"), manageCookieTtl)
+	m.Get("/-/manage/:id/:token", manageEnter(store, cookies))
+	m.Get("/-/manage/:id", manageGet(store, cookies, tmpl, "https://pow.example"))
+	m.Post("/-/manage/:id", managePost(store, noLists, cookies, tmpl, "https://pow.example"))
+
+	shortUrl, err := newShortUrl(ApiNewUrl{Url: "https://example.com/"}, nil, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := newOwnerToken(shortUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.createShortUrl(noLists, testIds, shortUrl, "", false); err != nil {
+		t.Fatal(err)
+	}
+	path := "/-/manage/" + shortUrl.Id
+
+	var cookie *http.Cookie
+	send := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := send("GET", path+"/nope", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("wrong token: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := send("GET", path, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("no cookie: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := send("POST", path, url.Values{"action": {"disable"}}); rec.Code != http.StatusNotFound {
+		t.Errorf("disable without cookie: got %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	// the ManageLink swaps the token for a cookie, and sends them on without it
+	rec := send("GET", path+"/"+token, nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != path {
+		t.Errorf("GET the ManageLink: got %d to %q, want %d to %q", rec.Code, rec.Header().Get("Location"), http.StatusSeeOther, path)
+	}
+	if rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("GET the ManageLink: got Referrer-Policy %q", rec.Header().Get("Referrer-Policy"))
+	}
+	if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].Path != path || !cookies[0].HttpOnly {
+		t.Fatalf("GET the ManageLink: got cookies %+v", cookies)
+	}
+	cookie = rec.Result().Cookies()[0]
+
+	rec = send("GET", path, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Errorf("GET %s: got %d with Referrer-Policy %q", path, rec.Code, rec.Header().Get("Referrer-Policy"))
+	}
+	if !strings.Contains(rec.Body.String(), `action="`+path+`"`) {
+		t.Errorf("GET %s: forms don't post to %s", path, path)
+	}
+	if rec := send("POST", path, url.Values{"action": {"disable"}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("disable: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if rec := send("POST", path, url.Values{"action": {"update"}, "url": {"nope"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid update: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	got, err := store.getShortUrl(shortUrl.Id)
+	if err != nil || got == nil || !got.Disabled {
+		t.Fatalf("after disable: got %+v (%v)", got, err)
+	}
+
+	if rec := send("POST", path, url.Values{"action": {"delete"}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("delete: got %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if got, _ := store.getShortUrl(shortUrl.Id); got != nil {
+		t.Errorf("after delete: got %+v", got)
+	}
+}
+
+func TestManageCookies(t *testing.T) {
+	c := newManageCookies([]byte("secret"), time.Hour)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	rec := httptest.NewRecorder()
+	c.set(rec, "abc", "token", t0)
+	cookie := rec.Result().Cookies()[0]
+
+	request := func(name, value string) *http.Request {
+		r := httptest.NewRequest("GET", "/-/manage/abc", nil)
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+		return r
+	}
+	parts := strings.Split(cookie.Value, ".")
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		id   string
+		t    time.Time
+		want string
+	}{
+		{"valid", request(cookie.Name, cookie.Value), "abc", t0, "token"},
+		{"expired", request(cookie.Name, cookie.Value), "abc", t0.Add(time.Hour), ""},
+		{"other id", request("pow-manage-def", cookie.Value), "def", t0, ""},
+		{"other token", request(cookie.Name, parts[0]+".other."+parts[2]), "abc", t0, ""},
+		{"later expiry", request(cookie.Name, "9999999999.token."+parts[2]), "abc", t0, ""},
+		{"garbage", request(cookie.Name, "nope"), "abc", t0, ""},
+		{"none", httptest.NewRequest("GET", "/-/manage/abc", nil), "abc", t0, ""},
+	}
+	for _, test := range tests {
+		if got := c.token(test.r, test.id, test.t); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
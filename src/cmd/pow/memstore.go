@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// memoryStore keeps everything in this process and forgets it all on a restart. Only one transaction can change it at
+// a time, and each remembers what it changed so it can all be put back if it fails.
+type memoryStore struct {
+	mu        sync.RWMutex
+	buckets   map[string]map[string][]byte
+	sequences map[string]uint64
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets:   make(map[string]map[string][]byte),
+		sequences: make(map[string]uint64),
+	}
+}
+
+func (s *memoryStore) view(fn func(tx storeTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) update(fn func(tx storeTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryTx{store: s, writable: true}
+	err := fn(tx)
+	if err != nil {
+		tx.rollback()
+	}
+	return err
+}
+
+func (s *memoryStore) close() error {
+	return nil
+}
+
+// memoryUndo is what a key (or if bucket is "", a sequence) was before a transaction changed it.
+type memoryUndo struct {
+	bucket string
+	key    string
+	value  []byte
+	ok     bool
+	seq    uint64
+}
+
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+	undo     []memoryUndo
+}
+
+// get returns a copy, so that nothing can change what's kept without a put (which could then never be undone).
+func (t *memoryTx) get(bucket, key string) ([]byte, error) {
+	value, ok := t.store.buckets[bucket][key]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, value...), nil
+}
+
+// remember saves what this key is now, so it can be put back by rollback.
+func (t *memoryTx) remember(bucket, key string) error {
+	if !t.writable {
+		return ErrReadOnlyTx
+	}
+	value, ok := t.store.buckets[bucket][key]
+	t.undo = append(t.undo, memoryUndo{bucket: bucket, key: key, value: value, ok: ok})
+	return nil
+}
+
+func (t *memoryTx) put(bucket, key string, value []byte) error {
+	if err := t.remember(bucket, key); err != nil {
+		return err
+	}
+	if t.store.buckets[bucket] == nil {
+		t.store.buckets[bucket] = make(map[string][]byte)
+	}
+	// copied, just like Bolt does, so the caller can't change it afterwards
+	t.store.buckets[bucket][key] = append([]byte{}, value...)
+	return nil
+}
+
+func (t *memoryTx) del(bucket, key string) error {
+	if err := t.remember(bucket, key); err != nil {
+		return err
+	}
+	delete(t.store.buckets[bucket], key)
+	return nil
+}
+
+func (t *memoryTx) each(bucket, after string, fn func(key string, value []byte) error) error {
+	keys := make([]string, 0, len(t.store.buckets[bucket]))
+	for key := range t.store.buckets[bucket] {
+		if key > after {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(key, append([]byte{}, t.store.buckets[bucket][key]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *memoryTx) nextSequence(bucket string) (uint64, error) {
+	if !t.writable {
+		return 0, ErrReadOnlyTx
+	}
+	t.undo = append(t.undo, memoryUndo{key: bucket, seq: t.store.sequences[bucket]})
+	t.store.sequences[bucket]++
+	return t.store.sequences[bucket], nil
+}
+
+// rollback puts everything this transaction changed back how it was, newest first.
+func (t *memoryTx) rollback() {
+	for i := len(t.undo) - 1; i >= 0; i-- {
+		undo := t.undo[i]
+		switch {
+		case undo.bucket == "":
+			t.store.sequences[undo.key] = undo.seq
+		case undo.ok:
+			t.store.buckets[undo.bucket][undo.key] = undo.value
+		default:
+			delete(t.store.buckets[undo.bucket], undo.key)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gomiddleware/logger"
+	"github.com/gomiddleware/mux"
+)
+
+const metaMaxBytes = 512 * 1024
+const metaTimeout = 5 * time.Second
+const metaMaxRedirects = 5
+const metaQueueLen = 100
+const metaRefreshMin = time.Minute // how often anyone may refresh the same ShortUrl
+const metaTitleMaxLen = 200
+const metaDescriptionMaxLen = 500
+const metaUrlMaxLen = 2000
+const metaUserAgent = "Mozilla/5.0 (compatible; pow-preview/1.0)"
+
+var (
+	ErrDestinationNotPublic = errors.New("Destination is not on the public internet")
+	ErrTooManyRedirects     = errors.New("Destination redirected too many times")
+	ErrMetaNotHtml          = errors.New("Destination is not an HTML page")
+)
+
+var titleRegExp = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+var metaTagRegExp = regexp.MustCompile(`(?is)<(meta|link)\s[^>]*>`)
+var htmlAttrRegExp = regexp.MustCompile(`([a-zA-Z_:.-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+// the shared address space used by carrier-grade NAT, which net.IP doesn't count as private
+var sharedNet = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Meta is what we found out about a ShortUrl's destination, for it's preview. If it couldn't be fetched, Error says
+// why.
+type Meta struct {
+	Url         string // the destination this was fetched from
+	Title       string `json:",omitempty"`
+	Description string `json:",omitempty"`
+	Image       string `json:",omitempty"`
+	SiteName    string `json:",omitempty"`
+	Favicon     string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	Fetched     time.Time
+}
+
+// isPublicIp tells you whether this address is somewhere on the internet, rather than on this machine or it's
+// private network.
+func isPublicIp(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !sharedNet.Contains(ip)
+}
+
+// newDestinationClient returns the client used to fetch metadata and check the health of destinations, which will
+// only connect to public addresses (so ShortUrls can't be used to look around our own network) and gives up on slow
+// destinations.
+func newDestinationClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: metaTimeout,
+		// checked after the name has been resolved, so DNS can't be used to get around it
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIp(ip) {
+				return ErrDestinationNotPublic
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: metaTimeout,
+		Transport: &http.Transport{
+			// no proxy, since it would connect for us without the check above
+			DialContext:            dialer.DialContext,
+			TLSHandshakeTimeout:    metaTimeout,
+			ResponseHeaderTimeout:  metaTimeout,
+			MaxResponseHeaderBytes: 64 * 1024,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= metaMaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidScheme
+			}
+			return nil
+		},
+	}
+}
+
+// fetchMeta fetches the metadata of this destination using this client. It always returns a Meta, with Error set if
+// it couldn't be fetched.
+func fetchMeta(client *http.Client, dest string, t time.Time) *Meta {
+	meta := &Meta{Url: dest, Fetched: t}
+
+	base, body, err := fetchHtml(client, dest)
+	if err != nil {
+		meta.Error = fetchError(err)
+		return meta
+	}
+
+	parseMeta(meta, base, body)
+	return meta
+}
+
+// fetchError describes why a destination couldn't be fetched, without repeating the destination itself.
+func fetchError(err error) string {
+	if errors.Is(err, ErrDestinationNotPublic) {
+		return ErrDestinationNotPublic.Error()
+	}
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// fetchHtml returns (up to metaMaxBytes of) the page at dest, along with where it ended up after any redirects.
+func fetchHtml(client *http.Client, dest string) (*url.URL, string, error) {
+	req, err := http.NewRequest("GET", dest, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", metaUserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", fmt.Errorf("Destination returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, "", ErrMetaNotHtml
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, metaMaxBytes))
+	if err != nil {
+		return nil, "", err
+	}
+	return resp.Request.URL, string(body), nil
+}
+
+// parseMeta fills in meta from this page, preferring Open Graph over Twitter cards over the plain HTML. Relative
+// Urls are resolved against base.
+func parseMeta(meta *Meta, base *url.URL, body string) {
+	// everything we want is in the head, if we can find the end of it
+	if end := strings.Index(strings.ToLower(body), "</head>"); end >= 0 {
+		body = body[:end]
+	}
+
+	props := make(map[string]string)
+	icon := ""
+	for _, tag := range metaTagRegExp.FindAllStringSubmatch(body, -1) {
+		attrs := htmlAttrs(tag[0])
+		switch strings.ToLower(tag[1]) {
+		case "meta":
+			key := strings.ToLower(attrs["property"])
+			if key == "" {
+				key = strings.ToLower(attrs["name"])
+			}
+			if key != "" && props[key] == "" {
+				props[key] = attrs["content"]
+			}
+		case "link":
+			if icon == "" && containsString(strings.Fields(strings.ToLower(attrs["rel"])), "icon") {
+				icon = attrs["href"]
+			}
+		}
+	}
+	title := ""
+	if m := titleRegExp.FindStringSubmatch(body); m != nil {
+		title = html.UnescapeString(m[1])
+	}
+	if icon == "" {
+		icon = "/favicon.ico"
+	}
+
+	meta.Title = cleanText(firstOf(props["og:title"], props["twitter:title"], title), metaTitleMaxLen)
+	meta.Description = cleanText(firstOf(props["og:description"], props["twitter:description"], props["description"]), metaDescriptionMaxLen)
+	meta.SiteName = cleanText(props["og:site_name"], metaTitleMaxLen)
+	meta.Image = resolveMetaUrl(base, firstOf(props["og:image"], props["og:image:url"], props["twitter:image"], props["twitter:image:src"]))
+	meta.Favicon = resolveMetaUrl(base, icon)
+}
+
+// htmlAttrs returns the (unescaped) attributes of this tag, by their lowercase name.
+func htmlAttrs(tag string) map[string]string {
+	attrs := make(map[string]string)
+	for _, m := range htmlAttrRegExp.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(m[2] + m[3] + m[4])
+	}
+	return attrs
+}
+
+func firstOf(strs ...string) string {
+	for _, str := range strs {
+		if strings.TrimSpace(str) != "" {
+			return str
+		}
+	}
+	return ""
+}
+
+// cleanText collapses all whitespace and cuts this text down to at most max characters.
+func cleanText(str string, max int) string {
+	str = strings.Join(strings.Fields(strings.ToValidUTF8(str, "")), " ")
+	if runes := []rune(str); len(runes) > max {
+		str = string(runes[:max-1]) + "…"
+	}
+	return str
+}
+
+// resolveMetaUrl returns this (possibly relative) Url as an absolute one, but only if it's http or https.
+func resolveMetaUrl(base *url.URL, str string) string {
+	if str == "" {
+		return ""
+	}
+	ref, err := url.Parse(strings.TrimSpace(str))
+	if err != nil {
+		return ""
+	}
+	u := base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.String()) > metaUrlMaxLen {
+		return ""
+	}
+	return u.String()
+}
+
+// getMeta returns the Meta for this id, or nil if it hasn't been fetched yet.
+func (s *bucketStore) getMeta(id string) (*Meta, error) {
+	var meta *Meta
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, metaBucketNameStr, id, &meta)
+	})
+	return meta, err
+}
+
+// putMeta saves the Meta for this id, as long as the ShortUrl still exists.
+func (s *bucketStore) putMeta(id string, meta *Meta) error {
+	return s.update(func(tx storeTx) error {
+		v, err := tx.get(urlBucketNameStr, id)
+		if err != nil || v == nil {
+			return err
+		}
+		return putJson(tx, metaBucketNameStr, id, meta)
+	})
+}
+
+// metaFetcher fetches the metadata of ShortUrls in the background, one at a time.
+type metaFetcher struct {
+	store  Store
+	client *http.Client
+	queue  chan string
+}
+
+func newMetaFetcher(store Store, client *http.Client) *metaFetcher {
+	return &metaFetcher{
+		store:  store,
+		client: client,
+		queue:  make(chan string, metaQueueLen),
+	}
+}
+
+// run fetches everything queued, and should be given it's own goroutine.
+func (f *metaFetcher) run() {
+	for id := range f.queue {
+		if _, err := f.refresh(id); err != nil {
+			log.Printf("meta: %s\n", err)
+		}
+	}
+}
+
+// enqueue asks for the metadata of this ShortUrl to be fetched. If the queue is full it is dropped, and will be asked
+// for again the next time it's preview is seen.
+func (f *metaFetcher) enqueue(id string) {
+	select {
+	case f.queue <- id:
+	default:
+	}
+}
+
+// refresh fetches the metadata of this ShortUrl's destination now, or returns nil if the ShortUrl doesn't exist.
+func (f *metaFetcher) refresh(id string) (*Meta, error) {
+	shortUrl, err := f.store.getShortUrl(id)
+	if err != nil || shortUrl == nil {
+		return nil, err
+	}
+
+	meta := fetchMeta(f.client, shortUrl.Url, now())
+	return meta, f.store.putMeta(id, meta)
+}
+
+// metaRefreshPost re-fetches the metadata of a ShortUrl, then goes back to it's preview. Protected ShortUrls must be
+// unlocked first, just like their preview.
+func metaRefreshPost(store Store, fetcher *metaFetcher, unlock *unlocker) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vals(r)["id"]
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("ShortUrlId", id)
+
+		takedown, err := store.getTakedown(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if takedown != nil || shortUrl == nil {
+			notFound(w, r)
+			return
+		}
+
+		t := now()
+		if shortUrl.Password == "" || unlock.isUnlocked(r, shortUrl, t) {
+			meta, err := store.getMeta(id)
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+
+			// so nobody can use us to hammer the destination
+			if meta == nil || meta.Url != shortUrl.Url || t.Sub(meta.Fetched) >= metaRefreshMin {
+				lgr.Print("refreshing-meta")
+				if _, err := fetcher.refresh(id); err != nil {
+					internalServerError(w, err)
+					return
+				}
+			}
+		}
+
+		http.Redirect(w, r, "/"+id+"+", http.StatusSeeOther)
+	}
+}
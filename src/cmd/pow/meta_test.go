@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublicIp(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1::1", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+	}
+	for _, test := range tests {
+		if got := isPublicIp(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("isPublicIp(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestFetchMeta(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte(`<html><head>
+				<title>Plain &amp; Simple</title>
+				<meta property="og:title" content="The  Open Graph Title">
+				<meta name="description" content="A description.">
+				<meta property="og:image" content="/img/card.png">
+				<meta property="og:site_name" content="Example">
+				<link rel="shortcut icon" href="/static/icon.png">
+				</head><body><meta property="og:title" content="Not in the head"></body></html>`))
+		case "/plain":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<title>Only a Title</title><link rel="icon" href="javascript:alert(1)">`))
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(strings.Repeat(" ", metaMaxBytes) + "<title>Too Far</title>"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"title":"nope"}`))
+		case "/missing":
+			http.NotFound(w, r)
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	client := srv.Client()
+	client.Timeout = 100 * time.Millisecond
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	meta := fetchMeta(client, srv.URL+"/page", t0)
+	if meta.Error != "" || meta.Title != "The Open Graph Title" || meta.Description != "A description." ||
+		meta.SiteName != "Example" || meta.Image != srv.URL+"/img/card.png" || meta.Favicon != srv.URL+"/static/icon.png" ||
+		!meta.Fetched.Equal(t0) {
+		t.Errorf("page: got %+v", meta)
+	}
+
+	meta = fetchMeta(client, srv.URL+"/plain", t0)
+	if meta.Error != "" || meta.Title != "Only a Title" || meta.Favicon != "" || meta.Image != "" {
+		t.Errorf("plain: got %+v", meta)
+	}
+
+	// anything past the size limit is never read
+	meta = fetchMeta(client, srv.URL+"/big", t0)
+	if meta.Error != "" || meta.Title != "" || meta.Favicon != srv.URL+"/favicon.ico" {
+		t.Errorf("big: got %+v", meta)
+	}
+
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{"/json", ErrMetaNotHtml.Error()},
+		{"/missing", "Destination returned 404 Not Found"},
+		{"/slow", "Client.Timeout exceeded"},
+	}
+	for _, test := range tests {
+		meta := fetchMeta(client, srv.URL+test.path, t0)
+		if !strings.Contains(meta.Error, test.err) || meta.Title != "" {
+			t.Errorf("%s: got %+v, want error %q", test.path, meta, test.err)
+		}
+	}
+
+	// the real client won't go anywhere near this machine
+	meta = fetchMeta(newDestinationClient(), srv.URL+"/page", t0)
+	if meta.Error != ErrDestinationNotPublic.Error() {
+		t.Errorf("meta client: got %+v, want ErrDestinationNotPublic", meta)
+	}
+}
+
+func TestCleanText(t *testing.T) {
+	if got := cleanText("  a\n\tb  c ", 10); got != "a b c" {
+		t.Errorf("got %q", got)
+	}
+	if got := cleanText("abcdef", 4); got != "abc…" {
+		t.Errorf("got %q", got)
+	}
+}
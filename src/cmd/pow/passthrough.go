@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// The ways a ShortUrl can pass the visitor's query string on to it's destination. With neither, the query is dropped.
+//
+// With "merge" the destination's own parameters always win, so an incoming parameter is only added if the destination
+// doesn't already have one with that name. With "override" the visitor's parameters win instead, so every destination
+// parameter sharing a name with an incoming one is removed first. Either way, the destination's parameters keep their
+// original order and encoding and the incoming ones (including repeats) are added after them in the order given.
+const queryMerge = "merge"
+const queryOverride = "override"
+
+var (
+	ErrInvalidQueryPassthrough = errors.New("Query passthrough must be either merge or override")
+	ErrNoPathPassthrough       = errors.New("This Short URL doesn't accept extra paths")
+	ErrInvalidEscape           = errors.New("The extra path or query isn't escaped properly")
+)
+
+func isQueryPassthrough(mode string) bool {
+	return mode == "" || mode == queryMerge || mode == queryOverride
+}
+
+// splitQuery splits a raw query string into it's "name=value" pairs, without decoding them.
+func splitQuery(raw string) []string {
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(raw, "&") {
+		if pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// queryName returns the decoded name of this "name=value" pair.
+func queryName(pair string) string {
+	name := pair
+	if i := strings.IndexByte(pair, '='); i >= 0 {
+		name = pair[:i]
+	}
+	if decoded, err := url.QueryUnescape(name); err == nil {
+		return decoded
+	}
+	return name
+}
+
+// mergeQuery combines the destination's raw query with the incoming one, according to the mode.
+func mergeQuery(dest, incoming, mode string) string {
+	destPairs := splitQuery(dest)
+	inPairs := splitQuery(incoming)
+	if len(inPairs) == 0 {
+		return dest
+	}
+
+	pairs := make([]string, 0, len(destPairs)+len(inPairs))
+	if mode == queryOverride {
+		names := make(map[string]bool)
+		for _, pair := range inPairs {
+			names[queryName(pair)] = true
+		}
+		for _, pair := range destPairs {
+			if !names[queryName(pair)] {
+				pairs = append(pairs, pair)
+			}
+		}
+		pairs = append(pairs, inPairs...)
+	} else {
+		names := make(map[string]bool)
+		for _, pair := range destPairs {
+			names[queryName(pair)] = true
+		}
+		pairs = append(pairs, destPairs...)
+		for _, pair := range inPairs {
+			if !names[queryName(pair)] {
+				pairs = append(pairs, pair)
+			}
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+// destination returns where this visit should be redirected to, given the target (either the ShortUrl's Url or that of
+// a matching rule), the raw incoming query and any (still escaped) path after the ShortUrl's id. If there is an extra
+// path and the ShortUrl has a Template, the expanded template is used instead of the target. ErrNoPathPassthrough is
+// returned if there is an extra path but this ShortUrl doesn't accept one, and ErrInvalidEscape if the extra path or
+// query the visitor gave can't be unescaped.
+func destination(shortUrl *ShortUrl, target, rawQuery, extraPath string) (string, error) {
+	// templates use the extra path themselves, rather than passing it through
+	if extraPath != "" && shortUrl.Template != "" {
+		expanded, err := expandTemplate(shortUrl.Template, extraPath, rawQuery)
+		if err != nil {
+			return "", err
+		}
+		target, extraPath = expanded, ""
+	}
+
+	if extraPath != "" && !shortUrl.PathPassthrough {
+		return "", ErrNoPathPassthrough
+	}
+	if shortUrl.QueryPassthrough == "" && extraPath == "" && shortUrl.Campaign == nil {
+		return target, nil
+	}
+
+	// the campaign counts as part of the destination, so the passthrough rules apply to it too
+	base, err := withCampaign(target, shortUrl.Campaign)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	if extraPath != "" {
+		joined := strings.TrimSuffix(u.EscapedPath(), "/") + "/" + extraPath
+		path, err := url.PathUnescape(joined)
+		if err != nil {
+			return "", ErrInvalidEscape
+		}
+		u.Path = path
+		u.RawPath = joined
+	}
+
+	if shortUrl.QueryPassthrough != "" {
+		u.RawQuery = mergeQuery(u.RawQuery, rawQuery, shortUrl.QueryPassthrough)
+	}
+
+	return u.String(), nil
+}
+
+// splitExtraPath splits an escaped request path such as "/abc/more/path" into the ShortUrl id ("abc") and the escaped
+// remainder ("more/path").
+func splitExtraPath(escapedPath string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(escapedPath, "/"), "/", 2)
+	id, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	if len(parts) == 1 {
+		return id, "", nil
+	}
+	return id, parts[1], nil
+}
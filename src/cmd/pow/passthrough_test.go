@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		dest     string
+		incoming string
+		mode     string
+		want     string
+	}{
+		{"a=1&b=2", "", queryMerge, "a=1&b=2"},
+		{"a=1&b=2", "b=3&c=4", queryMerge, "a=1&b=2&c=4"},
+		{"a=1&b=2", "b=3&c=4", queryOverride, "a=1&b=3&c=4"},
+		{"", "c=4&c=5", queryMerge, "c=4&c=5"},
+		{"q=a%20b", "utm_source=x", queryMerge, "q=a%20b&utm_source=x"},
+		{"a%5B%5D=1", "a[]=2", queryOverride, "a[]=2"},
+	}
+	for _, test := range tests {
+		if got := mergeQuery(test.dest, test.incoming, test.mode); got != test.want {
+			t.Errorf("mergeQuery(%q, %q, %q) = %q, want %q", test.dest, test.incoming, test.mode, got, test.want)
+		}
+	}
+
+	if _, err := newShortUrl(ApiNewUrl{Url: "https://example.com/", QueryPassthrough: "append"}, nil, now()); err == nil {
+		t.Error("invalid query passthrough accepted")
+	}
+}
+
+func TestDestination(t *testing.T) {
+	tests := []struct {
+		shortUrl  ShortUrl
+		rawQuery  string
+		extraPath string
+		want      string
+		err       error
+	}{
+		{ShortUrl{Url: "https://example.com/?a=1"}, "b=2", "", "https://example.com/?a=1", nil},
+		{ShortUrl{Url: "https://example.com/?a=1", QueryPassthrough: queryMerge}, "a=2&b=2", "", "https://example.com/?a=1&b=2", nil},
+		{ShortUrl{Url: "https://example.com/docs/", PathPassthrough: true}, "", "guide/intro%20page", "https://example.com/docs/guide/intro%20page", nil},
+		{ShortUrl{Url: "https://example.com/docs", PathPassthrough: true, QueryPassthrough: queryOverride}, "v=2", "api", "https://example.com/docs/api?v=2", nil},
+		{ShortUrl{Url: "https://example.com/"}, "", "more", "", ErrNoPathPassthrough},
+		{ShortUrl{Url: "https://example.com/", Template: "https://example.com/issues/{1}"}, "", "42", "https://example.com/issues/42", nil},
+		{ShortUrl{Url: "https://example.com/", Template: "https://example.com/issues/{1}"}, "", "", "https://example.com/", nil},
+		{ShortUrl{Url: "https://example.com/", Template: "https://example.com/issues/{1}"}, "", "%zz", "", ErrInvalidEscape},
+		{ShortUrl{Url: "https://example.com/", Template: "https://example.com/search?q={q}"}, "q=%zz", "x", "", ErrInvalidEscape},
+		{ShortUrl{Url: "https://example.com/docs/", PathPassthrough: true}, "", "%zz", "", ErrInvalidEscape},
+	}
+	for _, test := range tests {
+		got, err := destination(&test.shortUrl, test.shortUrl.Url, test.rawQuery, test.extraPath)
+		if got != test.want || err != test.err {
+			t.Errorf("destination(%s, %q, %q) = %q, %v, want %q, %v", test.shortUrl.Url, test.rawQuery, test.extraPath, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestSplitExtraPath(t *testing.T) {
+	tests := []struct {
+		path      string
+		id        string
+		extraPath string
+	}{
+		{"/abc", "abc", ""},
+		{"/abc/", "abc", ""},
+		{"/abc/more/path", "abc", "more/path"},
+		{"/ab%63/a%2Fb", "abc", "a%2Fb"},
+	}
+	for _, test := range tests {
+		id, extraPath, err := splitExtraPath(test.path)
+		if err != nil || id != test.id || extraPath != test.extraPath {
+			t.Errorf("splitExtraPath(%q) = %q, %q, %v", test.path, id, extraPath, err)
+		}
+	}
+}
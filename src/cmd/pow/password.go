@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const passwordMinLen = 4
+const passwordIterations = 100000
+const passwordSaltLen = 16
+const passwordKeyLen = 32
+
+var ErrPasswordTooShort = errors.New("Password must be at least 4 characters long")
+
+// hashPassword returns a salted PBKDF2 hash of this password in the form "pbkdf2-sha256$<iter>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// checkPassword tells you whether this password matches the hash previously returned from hashPassword().
+func checkPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := enc.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// redacted returns a copy of this ShortUrl without anything which gives away where it goes, for password protected
+// ShortUrls shown to those who haven't unlocked them.
+func (s ShortUrl) redacted() ShortUrl {
+	s.Password = ""
+	s.Url = ""
+	s.History = nil
+	s.Campaign = nil
+	s.Rules = nil
+	s.Variants = nil
+	s.DeepLink = nil
+	s.Template = ""
+	return s
+}
+
+// unlocker remembers which password protected ShortUrls a visitor has already unlocked, using a signed cookie per
+// ShortUrl, and limits how many wrong passwords each visitor can try per ShortUrl.
+type unlocker struct {
+	secret  []byte
+	ttl     time.Duration
+	limiter *attemptLimiter
+}
+
+func newUnlocker(secret []byte, ttl time.Duration) *unlocker {
+	return &unlocker{
+		secret:  secret,
+		ttl:     ttl,
+		limiter: newAttemptLimiter(5, 15*time.Minute),
+	}
+}
+
+func (u *unlocker) cookieName(shortUrl *ShortUrl) string {
+	return "pow-unlock-" + shortUrl.Id
+}
+
+// sign returns the MAC for this ShortUrl until this expiry. Since the password hash is included, changing the password
+// invalidates all previous cookies.
+func (u *unlocker) sign(shortUrl *ShortUrl, expiry string) string {
+	mac := hmac.New(sha256.New, u.secret)
+	mac.Write([]byte(shortUrl.Id + "." + expiry + "." + shortUrl.Password))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isUnlocked tells you whether this request has a valid (and unexpired) cookie for this ShortUrl.
+func (u *unlocker) isUnlocked(r *http.Request, shortUrl *ShortUrl, t time.Time) bool {
+	cookie, err := r.Cookie(u.cookieName(shortUrl))
+	if err != nil {
+		return false
+	}
+
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || t.Unix() >= expiry {
+		return false
+	}
+
+	return hmac.Equal([]byte(parts[1]), []byte(u.sign(shortUrl, parts[0])))
+}
+
+// unlock sets the cookie to say this visitor has unlocked this ShortUrl.
+func (u *unlocker) unlock(w http.ResponseWriter, shortUrl *ShortUrl, t time.Time) {
+	expires := t.Add(u.ttl)
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     u.cookieName(shortUrl),
+		Value:    expiry + "." + u.sign(shortUrl, expiry),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// passwordPrompt renders the page asking for the password, which is posted back to the same path.
+func passwordPrompt(w http.ResponseWriter, tmpl *template.Template, status int, path string, msg string) {
+	data := struct {
+		Path  string
+		Error string
+	}{
+		path,
+		msg,
+	}
+	renderStatus(w, tmpl, status, "password.html", data)
+}
+
+// attemptKey is the key wrong passwords are counted by, which is the ShortUrl and the client's IP together so that one
+// visitor guessing can't lock everyone else out.
+func attemptKey(id, ip string) string {
+	return id + ":ip:" + ip
+}
+
+// attemptLimiter allows at most `max` failures per key within each `window`.
+type attemptLimiter struct {
+	mu       sync.Mutex
+	max      int
+	window   time.Duration
+	failures map[string][]time.Time
+	pruned   time.Time
+}
+
+func newAttemptLimiter(max int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		max:      max,
+		window:   window,
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// prune removes failures for this key which are outside the window. Must be called with the lock held.
+func (l *attemptLimiter) prune(key string, t time.Time) []time.Time {
+	recent := l.failures[key][:0]
+	for _, failed := range l.failures[key] {
+		if t.Sub(failed) < l.window {
+			recent = append(recent, failed)
+		}
+	}
+
+	if len(recent) == 0 {
+		delete(l.failures, key)
+		return nil
+	}
+	l.failures[key] = recent
+	return recent
+}
+
+// pruneAll forgets every key whose failures have all left the window, since they'd otherwise be kept forever for
+// clients who never try again. Must be called with the lock held.
+func (l *attemptLimiter) pruneAll(t time.Time) {
+	if t.Sub(l.pruned) < limitPruneEvery {
+		return
+	}
+	for key := range l.failures {
+		l.prune(key, t)
+	}
+	l.pruned = t
+}
+
+// allow tells you whether another attempt is allowed for this key at time t, and if not, how long until it will be.
+func (l *attemptLimiter) allow(key string, t time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneAll(t)
+	recent := l.prune(key, t)
+	if len(recent) < l.max {
+		return true, 0
+	}
+
+	return false, recent[0].Add(l.window).Sub(t)
+}
+
+// fail records a failed attempt for this key at time t.
+func (l *attemptLimiter) fail(key string, t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.pruneAll(t)
+	l.failures[key] = append(l.prune(key, t), t)
+}
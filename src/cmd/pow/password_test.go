@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := hashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkPassword(hash, "open sesame") {
+		t.Error("right password refused")
+	}
+	if checkPassword(hash, "open sesame!") {
+		t.Error("wrong password accepted")
+	}
+	if checkPassword("open sesame", "open sesame") {
+		t.Error("password accepted against a malformed hash")
+	}
+
+	again, err := hashPassword("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == hash {
+		t.Error("same hash twice, so the salt isn't random")
+	}
+}
+
+func TestUnlocker(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	u := newUnlocker([]byte("secret"), time.Hour)
+	shortUrl := ShortUrl{Id: "abc", Password: "hash"}
+
+	rec := httptest.NewRecorder()
+	u.unlock(rec, &shortUrl, t0)
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+
+	req := httptest.NewRequest("GET", "/abc", nil)
+	if u.isUnlocked(req, &shortUrl, t0) {
+		t.Error("unlocked without a cookie")
+	}
+	req.AddCookie(cookies[0])
+
+	if !u.isUnlocked(req, &shortUrl, t0.Add(time.Minute)) {
+		t.Error("not unlocked with the cookie")
+	}
+	if u.isUnlocked(req, &shortUrl, t0.Add(time.Hour)) {
+		t.Error("unlocked after the cookie expired")
+	}
+
+	changed := ShortUrl{Id: "abc", Password: "new hash"}
+	if u.isUnlocked(req, &changed, t0) {
+		t.Error("unlocked after the password changed")
+	}
+
+	other := newUnlocker([]byte("other secret"), time.Hour)
+	if other.isUnlocked(req, &shortUrl, t0) {
+		t.Error("unlocked with a cookie signed by a different secret")
+	}
+
+	forged := httptest.NewRequest("GET", "/abc", nil)
+	forged.AddCookie(&http.Cookie{Name: u.cookieName(&shortUrl), Value: "9999999999.forged"})
+	if u.isUnlocked(forged, &shortUrl, t0) {
+		t.Error("unlocked with a forged cookie")
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	l := newAttemptLimiter(2, time.Minute)
+
+	l.fail("abc", t0)
+	if ok, _ := l.allow("abc", t0); !ok {
+		t.Error("refused after 1 failure")
+	}
+
+	l.fail("abc", t0.Add(10*time.Second))
+	ok, wait := l.allow("abc", t0.Add(20*time.Second))
+	if ok || wait != 40*time.Second {
+		t.Errorf("after 2 failures: got %v and wait %s, want refused and wait 40s", ok, wait)
+	}
+	if ok, _ := l.allow("xyz", t0); !ok {
+		t.Error("other keys refused")
+	}
+
+	if ok, _ := l.allow("abc", t0.Add(time.Minute)); !ok {
+		t.Error("still refused once the first failure left the window")
+	}
+
+	// keys nobody tries again are forgotten once their failures leave the window
+	l.fail("xyz", t0.Add(time.Minute))
+	l.allow("other", t0.Add(3*time.Minute))
+	if len(l.failures) != 0 {
+		t.Errorf("stale keys kept: %v", l.failures)
+	}
+
+	// and each visitor has their own limit for each ShortUrl
+	l.fail(attemptKey("abc", "192.0.2.1"), t0)
+	l.fail(attemptKey("abc", "192.0.2.1"), t0)
+	if ok, _ := l.allow(attemptKey("abc", "192.0.2.1"), t0); ok {
+		t.Error("allowed after 2 failures from the same visitor")
+	}
+	if ok, _ := l.allow(attemptKey("abc", "192.0.2.2"), t0); !ok {
+		t.Error("another visitor refused")
+	}
+}
+
+func TestApiPasswordHidden(t *testing.T) {
+	m := newTestApi(t)
+
+	created := ApiUrl{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/secret","Password":"open sesame"}`, &created)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: got %d, want %d", rec.Code, http.StatusCreated)
+	}
+
+	got := ApiUrl{}
+	apiRequest(t, m, "GET", "/api/v1/urls/"+created.Id, "", &got)
+	for _, apiUrl := range []ApiUrl{created, got} {
+		if !apiUrl.Protected || apiUrl.Password != "" || apiUrl.Url != "" {
+			t.Errorf("password or destination shown in %+v", apiUrl)
+		}
+	}
+
+	apiErr := ApiError{}
+	apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/","Password":"abc"}`, &apiErr)
+	if apiErr.Code != "invalid-password" {
+		t.Errorf("short password: got %+v, want invalid-password", apiErr)
+	}
+}
+
+func TestNewApiUrlRedactsProtected(t *testing.T) {
+	shortUrl := ShortUrl{
+		Id:       "abc",
+		Url:      "https://example.com/secret",
+		Password: "pbkdf2-sha256$1$c2FsdA$aGFzaA",
+		Owner:    "owner",
+		History:  []Destination{{Url: "https://example.com/old-secret", Until: time.Now()}},
+		Campaign: &Campaign{Source: "newsletter", Name: "launch"},
+		Rules:    []Rule{{Url: "https://example.com/rule", Platforms: []string{"ios"}}},
+		Variants: []Variant{{Name: "A", Url: "https://example.com/variant", Weight: 1}},
+		DeepLink: &DeepLink{App: "myapp://secret"},
+		Template: "https://example.com/secret/{1}",
+	}
+
+	apiUrl := newApiUrl(shortUrl, &Stats{}, "https://pow.example")
+	if !apiUrl.Protected {
+		t.Error("not marked as protected")
+	}
+	if apiUrl.Url != "" || apiUrl.Password != "" || apiUrl.Owner != "" || apiUrl.History != nil ||
+		apiUrl.Campaign != nil || apiUrl.Rules != nil || apiUrl.Variants != nil || apiUrl.DeepLink != nil || apiUrl.Template != "" {
+		t.Errorf("not redacted: %+v", apiUrl.ShortUrl)
+	}
+	if apiUrl.Link != "https://pow.example/abc" {
+		t.Errorf("redacted too much: %+v", apiUrl)
+	}
+
+	// and the ShortUrl itself is untouched
+	if shortUrl.Url == "" || shortUrl.History == nil {
+		t.Error("the original ShortUrl was changed")
+	}
+
+	shortUrl.Password = ""
+	apiUrl = newApiUrl(shortUrl, &Stats{}, "https://pow.example")
+	if apiUrl.Protected || apiUrl.Url == "" || apiUrl.History == nil || apiUrl.Owner != "" {
+		t.Errorf("unprotected ShortUrl redacted wrongly: %+v", apiUrl)
+	}
+}
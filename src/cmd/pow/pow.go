@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"html/template"
@@ -9,23 +10,28 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
-	"github.com/chilts/rod"
 	"github.com/garyburd/redigo/redis"
 	"github.com/gomiddleware/logger"
 	"github.com/gomiddleware/logit"
 	"github.com/gomiddleware/mux"
 )
 
-var urlBucketName = []byte("url")
 var urlBucketNameStr = "url"
-var statsBucketName = []byte("stats")
 var statsBucketNameStr = "stats"
-var doneBucketName = []byte("done") // as in "stats-done"
-var doneBucketNameStr = "done"      // as in "stats-done"
+var doneBucketNameStr = "done"         // as in "stats-done"
+var hitsBucketNameStr = "hits"         // only for ShortUrls with a MaxHits
+var takedownBucketNameStr = "takedown" // id -> Takedown
+var blockBucketNameStr = "block"       // pattern -> Block
+var destBucketNameStr = "dest"         // normalised destination -> id
+var campaignBucketNameStr = "campaign" // campaign name -> stats
+var tagBucketNameStr = "tag"           // "<tag>:<id>" for each tag of each ShortUrl
+var metaBucketNameStr = "meta"         // id -> what the destination is about, for previews
+var healthBucketNameStr = "health"     // id -> the last check of the destination
+var goneBucketNameStr = "gone"         // id -> Tombstone, for each reaped or deleted ShortUrl
 
 var (
 	ErrInvalidScheme            = errors.New("URL scheme must be http or https")
@@ -37,8 +43,6 @@ var (
 var domainRegExp = regexp.MustCompile(`^([a-zA-Z0-9-]+\.)+[a-zA-Z]{2,}$`)
 var invalidDashRegExp = regexp.MustCompile(`(\.-)|(-\.)`)
 
-var toDelete = []string{"RToXsy", "iyzqGc"}
-
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
@@ -74,6 +78,11 @@ func validateUrl(str string) (*url.URL, error) {
 }
 
 func main() {
+	// any args means we're running an admin command, not the server
+	if len(os.Args) > 1 {
+		os.Exit(cli(os.Args[1:]))
+	}
+
 	// setup the logger
 	lgr := logit.New(os.Stdout, "pow")
 
@@ -89,6 +98,83 @@ func main() {
 	tmpl, err := template.New("").ParseGlob("./templates/*.html")
 	check(err)
 
+	// the secret used to sign cookies, if not given then cookies won't survive a restart
+	cookieSecret := []byte(os.Getenv("POW_COOKIE_SECRET"))
+	if len(cookieSecret) == 0 {
+		fmt.Println("No POW_COOKIE_SECRET given, unlocked links will need to be unlocked again (and manage links visited again) after a restart")
+		cookieSecret = make([]byte, 32)
+		_, err = rand.Read(cookieSecret)
+		check(err)
+	}
+
+	// how long a password protected link stays unlocked for
+	unlockTtl := 24 * time.Hour
+	if str := os.Getenv("POW_UNLOCK_TTL"); str != "" {
+		unlockTtl, err = time.ParseDuration(str)
+		check(err)
+	}
+	unlock := newUnlocker(cookieSecret, unlockTtl)
+
+	// management tokens are kept in a cookie once the ManageLink has been visited
+	manage := newManageCookies(cookieSecret, manageCookieTtl)
+
+	// the redirect status used by links which don't choose their own
+	redirectStatus := defaultRedirectStatus
+	if str := os.Getenv("POW_REDIRECT_STATUS"); str != "" {
+		redirectStatus, err = parseRedirectStatus(str)
+		check(err)
+	}
+
+	// the custom schemes (e.g. "myapp") which deep links may open
+	appSchemes, err := parseAppSchemes(os.Getenv("POW_APP_SCHEMES"))
+	check(err)
+
+	// how often each destination is checked, or "off" to never check them
+	healthInterval := 24 * time.Hour
+	if str := os.Getenv("POW_HEALTH_INTERVAL"); str == "off" {
+		healthInterval = 0
+	} else if str != "" {
+		healthInterval, err = time.ParseDuration(str)
+		check(err)
+	}
+
+	// local blocklists of malware and phishing sites, which are reloaded on a SIGHUP and every POW_BLOCKLIST_RELOAD
+	lists := newBlocklists(os.Getenv("POW_BLOCKLISTS"))
+	check(lists.load())
+	blocklistReload := time.Hour
+	if str := os.Getenv("POW_BLOCKLIST_RELOAD"); str == "off" {
+		blocklistReload = 0
+	} else if str != "" {
+		blocklistReload, err = time.ParseDuration(str)
+		check(err)
+	}
+	go lists.reloader(blocklistReload)
+
+	// the IDs given to new ShortUrls without a slug, which with the POW_ID_STRATEGY of random (the default) are made of
+	// POW_ID_LENGTH random characters from POW_ID_ALPHABET
+	idAlphabet, err := parseIdAlphabet(os.Getenv("POW_ID_ALPHABET"))
+	check(err)
+	idLength, err := parseIdLength(os.Getenv("POW_ID_LENGTH"))
+	check(err)
+	ids, err := newIdGenerator(os.Getenv("POW_ID_STRATEGY"), idAlphabet, idLength)
+	check(err)
+
+	// anonymous ShortUrls need a proof of work of at least POW_PROOF_OF_WORK bits, if it's set, unless the client has
+	// one of the POW_API_KEYS
+	keys := parseApiKeys(os.Getenv("POW_API_KEYS"))
+	var proof *hashcash
+	if str := os.Getenv("POW_PROOF_OF_WORK"); str != "" {
+		bits, err := parseHashcashBits(str)
+		check(err)
+		proof = newHashcash(cookieSecret, bits, keys)
+	}
+
+	// the admin API is only available if a token is set
+	adminToken := os.Getenv("POW_ADMIN_TOKEN")
+	if adminToken == "" {
+		fmt.Println("No POW_ADMIN_TOKEN given, the admin API is disabled")
+	}
+
 	// connect to Redis if specified
 	var redisPool *redis.Pool
 	redisAddr := os.Getenv("POW_REDIS_ADDR")
@@ -108,42 +194,54 @@ func main() {
 		lgr.Print("redis-not-configured")
 	}
 
-	// open the datastore
-	db, err := bolt.Open("pow.db", 0600, &bolt.Options{Timeout: 1 * time.Second})
+	// how fast each client may create ShortUrls, visit them, and use the rest of the API, where those with an API key
+	// are limited by it rather than their IP address (which is taken from X-Forwarded-For if set by a trusted proxy)
+	proxies, err := parseTrustedProxies(os.Getenv("POW_TRUSTED_PROXIES"))
 	check(err)
-	defer db.Close()
-
-	// create the main buckets
-	err = db.Update(func(tx *bolt.Tx) error {
-		var err error
-
-		urlBucket, err := tx.CreateBucketIfNotExists(urlBucketName)
-		if err != nil {
-			return err
-		}
-
-		// delete abusive URLs
-		fmt.Printf("Removing URLs ...\n")
-		for _, v := range toDelete {
-			fmt.Printf("Removing URL=%s\n", v)
-			err = urlBucket.Delete([]byte(v))
-			if err != nil {
-				return err
-			}
-		}
-		fmt.Printf("Done\n")
-
-		_, err = tx.CreateBucketIfNotExists(statsBucketName)
-		if err != nil {
-			return err
+	var limits limitStore
+	switch os.Getenv("POW_RATE_LIMIT_STORE") {
+	case "", "memory":
+		limits = newMemoryLimits()
+	case "redis":
+		if redisPool == nil {
+			log.Fatal("POW_RATE_LIMIT_STORE is redis, but no POW_REDIS_ADDR was given")
 		}
+		limits = &redisLimits{pool: redisPool}
+	default:
+		check(ErrInvalidLimitStore)
+	}
+	limiter := newRateLimiter(limits, keys, proxies)
+	createRate, createKeyRate, err := parseRates(os.Getenv("POW_RATE_LIMIT_CREATE"), os.Getenv("POW_RATE_LIMIT_CREATE_KEY"))
+	check(err)
+	redirectRate, redirectKeyRate, err := parseRates(os.Getenv("POW_RATE_LIMIT_REDIRECT"), os.Getenv("POW_RATE_LIMIT_REDIRECT_KEY"))
+	check(err)
+	apiRate, apiKeyRate, err := parseRates(os.Getenv("POW_RATE_LIMIT_API"), os.Getenv("POW_RATE_LIMIT_API_KEY"))
+	check(err)
+	limitCreate := limiter.limit("create", createRate, createKeyRate)
+	limitRedirect := limiter.limit("redirect", redirectRate, redirectKeyRate)
+	limitApi := limiter.limit("api", apiRate, apiKeyRate)
 
-		return nil
-	})
+	// open the datastore, which is Bolt unless POW_STORE says otherwise
+	store, err := openStoreFromEnv()
 	check(err)
+	defer store.close()
 
 	// Run the stats at regular intervals to process the hits from the previous hour.
-	go stats(redisPool, db)
+	go stats(redisPool, store)
+
+	// Regularly remove any expired ShortUrls.
+	go reaper(store)
+
+	// Fetch the metadata of destinations for their previews.
+	fetcher := newMetaFetcher(store, newDestinationClient())
+	go fetcher.run()
+
+	// Check that destinations still work.
+	if healthInterval > 0 {
+		go healthChecker(store, newDestinationClient(), healthInterval)
+	} else {
+		fmt.Println("POW_HEALTH_INTERVAL is off, destinations will not be checked")
+	}
 
 	// the mux
 	m := mux.New()
@@ -156,131 +254,459 @@ func main() {
 	m.Get("/robots.txt", serveFile("./static/robots.txt"))
 
 	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := proof.newChallenge(now())
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+
 		data := struct {
 			NakedDomain string
 			BaseUrl     string
+			Slug        string
+			Challenge   *Challenge
 		}{
 			nakedDomain,
 			baseUrl,
+			r.FormValue("slug"),
+			challenge,
+		}
+		if challenge != nil {
+			w.Header().Set("Cache-Control", "no-store")
 		}
 		render(w, tmpl, "index.html", data)
 	})
 
 	m.Get("/new", func(w http.ResponseWriter, r *http.Request) {
+		challenge, err := proof.newChallenge(now())
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+
 		data := struct {
-			BaseUrl string
+			BaseUrl   string
+			Slug      string
+			Challenge *Challenge
 		}{
 			baseUrl,
+			r.FormValue("slug"),
+			challenge,
+		}
+		if challenge != nil {
+			w.Header().Set("Cache-Control", "no-store")
 		}
 		render(w, tmpl, "new.html", data)
 	})
 
-	m.Post("/new", func(w http.ResponseWriter, r *http.Request) {
-		// validate the URL
-		u, err := validateUrl(r.FormValue("url"))
+	m.Post("/new", limitCreate, func(w http.ResponseWriter, r *http.Request) {
+		hold, err := proof.require(r, now())
 		if err != nil {
-			internalServerError(w, err)
+			logger.LogFromRequest(r).Print("proof-of-work-failed")
+			forbidden(w, err)
 			return
 		}
+		defer hold.release()
 
-		fmt.Printf("url=%s\n", u)
+		newUrl, err := newUrlFromForm(r)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
 
-		// setup a few things
-		var id string
-		now := time.Now().UTC()
-		shortUrl := ShortUrl{
-			Id:      "", // filled in later
-			Url:     u.String(),
-			Created: now,
-			Updated: now,
+		// validate everything
+		shortUrl, err := newShortUrl(newUrl, appSchemes, now())
+		if err != nil {
+			badRequest(w, err)
+			return
 		}
 
-		err = db.Update(func(tx *bolt.Tx) error {
-			// keep generating IDs until we find a unique one
-			for {
-				// generate a new Id
-				id = Id(6)
-				fmt.Printf("id=%s\n", id)
+		fmt.Printf("url=%s\n", shortUrl.Url)
 
-				// see if it already exists
-				v, err := rod.Get(tx, urlBucketNameStr, id)
-				if err != nil {
-					return err
-				}
-				if v == nil {
-					// this id does not yet exist, so quite the loop
-					break
-				}
-				// ID exists, loop again ...
+		// shared ShortUrls don't belong to anyone
+		reuse := wantsReuse(newUrl, shortUrl)
+		token := ""
+		if !reuse {
+			token, err = newOwnerToken(shortUrl)
+			if err != nil {
+				internalServerError(w, err)
+				return
 			}
+		}
 
-			shortUrl.Id = id
-			return rod.PutJson(tx, urlBucketNameStr, id, shortUrl)
-		})
-
+		reused, err := store.createShortUrl(lists, ids, shortUrl, newUrl.Slug, reuse)
+		if err == ErrSlugTaken {
+			conflict(w, err)
+			return
+		}
+		if err == ErrDestinationBlocked {
+			badRequest(w, err)
+			return
+		}
 		if err != nil {
 			internalServerError(w, err)
 			return
 		}
 
-		http.Redirect(w, r, "/"+id+"+", http.StatusFound)
+		hold.spend(now())
+		if !reused {
+			fetcher.enqueue(shortUrl.Id)
+		}
+
+		// shared ShortUrls have nothing for this creator to manage
+		if token == "" {
+			http.Redirect(w, r, "/"+shortUrl.Id+"+", http.StatusFound)
+			return
+		}
+
+		// show the management page, since this is the only time they'll get the token, which goes straight into a
+		// cookie rather than the address bar
+		manage.set(w, shortUrl.Id, token, now())
+		http.Redirect(w, r, managePath(shortUrl.Id), http.StatusFound)
 	})
 
-	m.Get("/:id", func(w http.ResponseWriter, r *http.Request) {
+	// the JSON API, where the limit is on "/api" (not "/api/") since mux matches prefixes by whole path segments
+	m.Use("/api", limitApi)
+	m.Post("/api/v1/urls", limitCreate, apiCreateUrl(store, lists, ids, baseUrl, appSchemes, fetcher, proof))
+	m.Get("/api/v1/urls", requireApiKey(keys, adminToken), apiListUrls(store, baseUrl))
+	m.Post("/api/v1/urls/bulk", limitCreate, apiBulk(store, lists, ids, keys, baseUrl, appSchemes, fetcher))
+	m.Get("/api/v1/urls/:id", apiGetUrl(store, baseUrl))
+	m.Patch("/api/v1/urls/:id", apiUpdateUrl(store, lists, baseUrl, appSchemes))
+	m.Delete("/api/v1/urls/:id", apiDeleteUrl(store))
+	m.Get("/api/v1/urls/:id/campaigns/:name", apiGetCampaign(store, adminToken))
+	m.Get("/api/v1/challenge", apiNewChallenge(proof))
+	m.Get("/api/v1/search", apiSearch(store, baseUrl))
+	m.Get("/api/v1/tags", apiListTags(store))
+
+	// bulk uploads
+	m.Get("/-/bulk", func(w http.ResponseWriter, r *http.Request) {
+		renderBulk(w, tmpl, http.StatusOK, "")
+	})
+	m.Post("/-/bulk", limitCreate, bulkPost(store, lists, ids, keys, tmpl, baseUrl, appSchemes, fetcher))
+
+	// admin
+	admin := requireAdmin(adminToken)
+	m.Get("/api/v1/admin/takedowns", admin, adminListTakedowns(store))
+	m.Post("/api/v1/admin/takedowns", admin, adminCreateTakedown(store))
+	m.Delete("/api/v1/admin/takedowns/:id", admin, adminDeleteTakedown(store))
+	m.Get("/api/v1/admin/blocks", admin, adminListBlocks(store))
+	m.Post("/api/v1/admin/blocks", admin, adminCreateBlock(store))
+	m.Delete("/api/v1/admin/blocks", admin, adminDeleteBlock(store))
+	m.Get("/api/v1/admin/broken", admin, adminListBrokenLinks(store))
+	m.Get("/-/admin/broken", admin, adminBrokenLinksPage(store, tmpl, baseUrl))
+
+	// finding ShortUrls
+	m.Get("/-/search", searchGet(store, tmpl, baseUrl))
+
+	// re-fetching the metadata shown on a preview
+	m.Post("/-/meta/:id", metaRefreshPost(store, fetcher, unlock))
+
+	// managing a ShortUrl with it's secret token
+	m.Get("/-/manage/:id/:token", manageEnter(store, manage))
+	m.Get("/-/manage/:id", manageGet(store, manage, tmpl, baseUrl))
+	m.Post("/-/manage/:id", managePost(store, lists, manage, tmpl, baseUrl))
+
+	// visit redirects to (or previews) a ShortUrl, where extraPath is anything (still escaped) after the id
+	visit := func(w http.ResponseWriter, r *http.Request, id, extraPath string) {
 		var preview bool
-		id := mux.Vals(r)["id"]
 		fmt.Printf("id=%s\n", id)
 
+		// QR Codes such as "/abc.png" or "/abc.svg"
+		if qrId, format, ok := isQrCode(id); ok && extraPath == "" {
+			serveQrCode(store, baseUrl, w, r, qrId, format)
+			return
+		}
+
 		lgr := logger.LogFromRequest(r)
 		lgr.WithField("ShortUrlId", id)
 
 		// decide if we're redirecting or viewing the preview page (https://play.golang.org/p/Mkpb9gAzN1)
-		if strings.HasSuffix(id, "+") {
+		if strings.HasSuffix(id, "+") && extraPath == "" {
 			id = strings.TrimSuffix(id, "+")
 			preview = true
 		}
 
+		// taken down ShortUrls explain why, even if the ShortUrl itself no longer exists
+		takedown, err := store.getTakedown(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if takedown != nil {
+			lgr.Print("short-url-taken-down")
+			takenDown(w, tmpl, takedown.Status, takedown.Reason)
+			return
+		}
+
+		// and so do ShortUrls which have been reaped or deleted since
+		tombstone, err := store.getTombstone(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if tombstone != nil {
+			lgr.Print("short-url-gone")
+			gone(w, tmpl, tombstone.Reason)
+			return
+		}
+
 		// get the shortUrl if it exists
-		var shortUrl *ShortUrl
-		err := db.View(func(tx *bolt.Tx) error {
-			return rod.GetJson(tx, urlBucketNameStr, id, &shortUrl)
-		})
+		shortUrl, err := store.getShortUrl(id)
 		if err != nil {
 			internalServerError(w, err)
 			return
 		}
 		if shortUrl == nil {
 			lgr.Print("no-short-url-found")
+			renderSearch(w, tmpl, http.StatusNotFound, store, baseUrl, id, "", true)
+			return
+		}
+
+		// see if this shortUrl has expired (though it may not have been reaped yet)
+		t := now()
+		if shortUrl.hasExpired(t) {
+			lgr.Print("short-url-expired")
+			gone(w, tmpl, expiredReason)
+			return
+		}
+		if shortUrl.Disabled {
+			lgr.Print("short-url-disabled")
+			gone(w, tmpl, "This link has been disabled by its owner.")
+			return
+		}
+
+		// where we're going, from any matching rule or else one of the variants, and including any query or path passed
+		// through
+		v := newVisitor(r, t)
+		target, ruled := shortUrl.target(v)
+		var variant *Variant
+		if !ruled && len(shortUrl.Variants) > 0 {
+			variant = chooseVariant(r, shortUrl)
+			target = variant.Url
+		}
+		dest, err := destination(shortUrl, target, r.URL.RawQuery, extraPath)
+		if err == ErrNoPathPassthrough {
+			lgr.Print("no-path-passthrough")
 			notFound(w, r)
 			return
 		}
+		if err == ErrInvalidEscape {
+			lgr.Print("invalid-escape")
+			badRequest(w, err)
+			return
+		}
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+
+		// phones opening the app may end up at it's store instead, which is checked just like the destination
+		reachable := []string{dest}
+		if !ruled {
+			reachable = shortUrl.DeepLink.reachable(v.platform, dest)
+		}
+
+		// the destination may have been blocked since this ShortUrl was created
+		for _, u := range reachable {
+			block, err := store.findBlock(u)
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+			if block != nil {
+				lgr.Print("short-url-destination-blocked")
+				takenDown(w, tmpl, http.StatusUnavailableForLegalReasons, block.Reason)
+				return
+			}
+		}
+
+		// password protected ShortUrls need to be unlocked first, whether redirecting or previewing
+		if shortUrl.Password != "" && !unlock.isUnlocked(r, shortUrl, t) {
+			lgr.Print("short-url-locked")
+			passwordPrompt(w, tmpl, http.StatusOK, r.URL.RequestURI(), "")
+			return
+		}
+
+		// the destination may also be on one of the blocklists, in which case we warn rather than redirect. This is only
+		// once unlocked, since the warning shows where it goes
+		for _, u := range reachable {
+			if list := lists.match(u); list != "" {
+				lgr.WithField("Blocklist", list)
+				lgr.Print("short-url-destination-listed")
+				warnListed(w, tmpl, u)
+				return
+			}
+		}
 
 		if preview {
+			hits, err := store.getHits(id)
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+			if shortUrl.isUsedUp(hits) {
+				lgr.Print("short-url-used-up")
+				gone(w, tmpl, usedUpReason)
+				return
+			}
+
 			// get the stats (if it exists)
-			stats := Stats{}
-			err := db.View(func(tx *bolt.Tx) error {
-				return rod.GetJson(tx, statsBucketNameStr, id, &stats)
-			})
+			stats, err := store.getStats(id)
 			if err != nil {
 				internalServerError(w, err)
 				return
 			}
 			fmt.Printf("stats=%#v\n", stats)
 
+			// and what the destination is about, which is fetched now if we don't know yet
+			meta, err := store.getMeta(id)
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+			if meta == nil || meta.Url != shortUrl.Url {
+				fetcher.enqueue(id)
+				meta = nil
+			}
+
+			// and whether it still works, as long as that was for the current destination
+			healths, err := store.getHealth(id)
+			if err != nil {
+				internalServerError(w, err)
+				return
+			}
+			health := findHealth(healths, shortUrl.Url)
+
 			lgr.Print("rendering-preview")
 			data := struct {
-				BaseUrl  string
-				ShortUrl *ShortUrl
-				Stats    *Stats
+				BaseUrl   string
+				ShortUrl  *ShortUrl
+				Stats     *Stats
+				Variants  []VariantStats
+				Meta      *Meta
+				Health    *Health
+				ExpiresIn string
+				HitsLeft  int64
 			}{
 				baseUrl,
 				shortUrl,
-				&stats,
+				stats,
+				variantStats(shortUrl, stats),
+				meta,
+				health,
+				"",
+				shortUrl.MaxHits - hits,
+			}
+			if shortUrl.ExpiresAt != nil {
+				data.ExpiresIn = expiresIn(shortUrl.ExpiresAt.Sub(t))
 			}
 			render(w, tmpl, "preview.html", data)
 		} else {
-			go incHits(redisPool, id)
-			http.Redirect(w, r, shortUrl.Url, http.StatusMovedPermanently)
+			if shortUrl.MaxHits > 0 {
+				ok, err := store.useHit(shortUrl)
+				if err != nil {
+					internalServerError(w, err)
+					return
+				}
+				if !ok {
+					lgr.Print("short-url-used-up")
+					gone(w, tmpl, usedUpReason)
+					return
+				}
+			}
+
+			variantName := ""
+			if variant != nil {
+				variantName = variant.Name
+				lgr.WithField("Variant", variantName)
+				if shortUrl.Sticky {
+					stickToVariant(w, shortUrl, variant, t)
+				}
+			}
+
+			go incHits(redisPool, id, variantName, campaignName(shortUrl))
+
+			// phones try to open the app first, unless a rule says otherwise
+			if !ruled && shortUrl.DeepLink.opensApp(v.platform) {
+				lgr.Print("opening-app")
+				openApp(w, tmpl, shortUrl.DeepLink, v.platform, dest)
+				return
+			}
+
+			redirectTo(w, r, dest, shortUrl, redirectStatus, t)
+		}
+	}
+
+	// unlockPost checks the password for a ShortUrl and if correct, sends them back to where they were going
+	unlockPost := func(w http.ResponseWriter, r *http.Request, id string) {
+		path := r.URL.RequestURI()
+		id = strings.TrimSuffix(id, "+")
+
+		lgr := logger.LogFromRequest(r)
+		lgr.WithField("ShortUrlId", id)
+
+		shortUrl, err := store.getShortUrl(id)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		if shortUrl == nil {
+			lgr.Print("no-short-url-found")
+			notFound(w, r)
+			return
+		}
+
+		// nothing to unlock, so just go back
+		if shortUrl.Password == "" {
+			http.Redirect(w, r, path, http.StatusSeeOther)
+			return
+		}
+
+		t := now()
+		key := attemptKey(id, proxies.clientIp(r))
+		ok, wait := unlock.limiter.allow(key, t)
+		if !ok {
+			lgr.Print("too-many-password-attempts")
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			passwordPrompt(w, tmpl, http.StatusTooManyRequests, path, "Too many wrong passwords, please try again in "+expiresIn(wait)+".")
+			return
+		}
+
+		if !checkPassword(shortUrl.Password, r.FormValue("password")) {
+			lgr.Print("wrong-password")
+			unlock.limiter.fail(key, t)
+			passwordPrompt(w, tmpl, http.StatusForbidden, path, "Wrong password, please try again.")
+			return
+		}
+
+		// unlocked, so go back to where they were going with the cookie set
+		lgr.Print("short-url-unlocked")
+		unlock.unlock(w, shortUrl, t)
+		http.Redirect(w, r, path, http.StatusSeeOther)
+	}
+
+	m.Get("/:id", limitRedirect, func(w http.ResponseWriter, r *http.Request) {
+		visit(w, r, mux.Vals(r)["id"], "")
+	})
+	m.Post("/:id", limitRedirect, func(w http.ResponseWriter, r *http.Request) {
+		unlockPost(w, r, mux.Vals(r)["id"])
+	})
+
+	// anything else might be a ShortUrl with an extra path, such as "/abc/more/path"
+	m.All("/", limitRedirect, func(w http.ResponseWriter, r *http.Request) {
+		id, extraPath, err := splitExtraPath(r.URL.EscapedPath())
+		if err != nil {
+			notFound(w, r)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			visit(w, r, id, extraPath)
+		case http.MethodPost:
+			unlockPost(w, r, id)
+		default:
+			notFound(w, r)
 		}
 	})
 
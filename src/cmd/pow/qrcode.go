@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gomiddleware/logger"
+
+	"qr"
+)
+
+const qrDefaultSize = 256
+const qrMaxSize = 2048
+const qrDefaultMargin = 4
+const qrMaxMargin = 20
+const qrMaxAge = "300" // seconds, so a takedown reaches caches soon after
+
+var (
+	ErrInvalidQrSize   = errors.New("Size must be a number of pixels between 1 and 2048")
+	ErrInvalidQrMargin = errors.New("Margin must be a number of modules between 0 and 20")
+	ErrInvalidQrLevel  = errors.New("Error correction must be one of L, M, Q or H")
+)
+
+// isQrCode tells you whether this id is asking for a QR Code (e.g. "abc.png" or "abc.svg") and if so, returns the
+// ShortUrl id and the image format. Ids can't contain a '.' so there is no ambiguity.
+func isQrCode(id string) (string, string, bool) {
+	ext := path.Ext(id)
+	if ext != ".png" && ext != ".svg" {
+		return id, "", false
+	}
+	return strings.TrimSuffix(id, ext), ext[1:], true
+}
+
+// qrCodeOptions reads the size (in pixels), margin (in modules) and ec (error correction level) from the query string.
+func qrCodeOptions(r *http.Request) (size, margin int, level qr.Level, err error) {
+	size, margin, level = qrDefaultSize, qrDefaultMargin, qr.M
+
+	if str := r.FormValue("size"); str != "" {
+		size, err = strconv.Atoi(str)
+		if err != nil || size < 1 || size > qrMaxSize {
+			return 0, 0, 0, ErrInvalidQrSize
+		}
+	}
+	if str := r.FormValue("margin"); str != "" {
+		margin, err = strconv.Atoi(str)
+		if err != nil || margin < 0 || margin > qrMaxMargin {
+			return 0, 0, 0, ErrInvalidQrMargin
+		}
+	}
+	if str := r.FormValue("ec"); str != "" {
+		level, err = qr.ParseLevel(str)
+		if err != nil {
+			return 0, 0, 0, ErrInvalidQrLevel
+		}
+	}
+
+	return size, margin, level, nil
+}
+
+// serveQrCode renders a QR Code of the short link (not the destination) as either a PNG or an SVG. The image is scaled
+// to the largest whole number of pixels per module which fits within the requested size.
+func serveQrCode(store Store, baseUrl string, w http.ResponseWriter, r *http.Request, id, format string) {
+	lgr := logger.LogFromRequest(r)
+	lgr.WithField("ShortUrlId", id)
+	lgr.WithField("Format", format)
+
+	size, margin, level, err := qrCodeOptions(r)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	takedown, err := store.getTakedown(id)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	shortUrl, err := store.getShortUrl(id)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+	if takedown != nil || shortUrl == nil {
+		lgr.Print("no-short-url-found")
+		notFound(w, r)
+		return
+	}
+
+	code, err := qr.Encode([]byte(baseUrl+"/"+id), level)
+	if err != nil {
+		internalServerError(w, err)
+		return
+	}
+
+	scale := size / (code.Size + 2*margin)
+	if scale < 1 {
+		scale = 1
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age="+qrMaxAge)
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(code.SVG(scale, margin))
+	} else {
+		img, err := code.PNG(scale, margin)
+		if err != nil {
+			internalServerError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(img)
+	}
+
+	lgr.Print("rendered-qr-code")
+}
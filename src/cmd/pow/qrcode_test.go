@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gomiddleware/mux"
+)
+
+func TestIsQrCode(t *testing.T) {
+	tests := []struct {
+		id     string
+		want   string
+		format string
+		ok     bool
+	}{
+		{"abc.png", "abc", "png", true},
+		{"abc.svg", "abc", "svg", true},
+		{"abc.gif", "abc.gif", "", false},
+		{"abc", "abc", "", false},
+	}
+	for _, test := range tests {
+		id, format, ok := isQrCode(test.id)
+		if id != test.want || format != test.format || ok != test.ok {
+			t.Errorf("isQrCode(%q) = %q, %q, %v", test.id, id, format, ok)
+		}
+	}
+}
+
+func TestServeQrCode(t *testing.T) {
+	store := newTestStore(t)
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "launch", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "phish", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.putTakedown(&Takedown{Id: "phish", Reason: "Phishing.", Status: 451}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestMux()
+	m.Get("/:id", func(w http.ResponseWriter, r *http.Request) {
+		id, format, _ := isQrCode(mux.Vals(r)["id"])
+		serveQrCode(store, "https://pow.example", w, r, id, format)
+	})
+
+	tests := []struct {
+		path        string
+		status      int
+		contentType string
+	}{
+		{"/launch.png", http.StatusOK, "image/png"},
+		{"/launch.svg?size=100&margin=0&ec=H", http.StatusOK, "image/svg+xml"},
+		{"/launch.png?size=0", http.StatusBadRequest, ""},
+		{"/launch.png?ec=X", http.StatusBadRequest, ""},
+		{"/nope.png", http.StatusNotFound, ""},
+		{"/phish.png", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.status {
+			t.Errorf("GET %s: got %d, want %d", test.path, rec.Code, test.status)
+			continue
+		}
+		if test.contentType == "" {
+			continue
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("GET %s: got Content-Type %q, want %q", test.path, ct, test.contentType)
+		}
+		if cc := rec.Header().Get("Cache-Control"); !strings.Contains(cc, "max-age="+qrMaxAge) {
+			t.Errorf("GET %s: got Cache-Control %q", test.path, cc)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/gomiddleware/logger"
+)
+
+const limitPruneEvery = time.Minute
+
+var (
+	ErrInvalidRate         = errors.New("Rate limits must be a number of requests per s, m, h or a duration, e.g. 30/m or 100/10m")
+	ErrInvalidTrustedProxy = errors.New("POW_TRUSTED_PROXIES must be a comma separated list of IP addresses or CIDRs")
+	ErrInvalidLimitStore   = errors.New("POW_RATE_LIMIT_STORE must be either memory or redis")
+	ErrRateLimited         = errors.New("Too many requests, please slow down")
+)
+
+// rate allows up to limit requests at once, refilling at limit requests every per.
+type rate struct {
+	limit int
+	per   time.Duration
+}
+
+// parseRate parses a rate such as "30/m", "5/s" or "100/10m", where "" or "off" means no limit at all.
+func parseRate(str string) (*rate, error) {
+	if str == "" || str == "off" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(str, "/", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidRate
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 1 {
+		return nil, ErrInvalidRate
+	}
+
+	var per time.Duration
+	switch parts[1] {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		per, err = time.ParseDuration(parts[1])
+		if err != nil || per <= 0 {
+			return nil, ErrInvalidRate
+		}
+	}
+
+	return &rate{limit: limit, per: per}, nil
+}
+
+// parseRates parses the rates for anonymous clients and those with an API key, where the keyed one is the same as the
+// anonymous one unless given.
+func parseRates(anonStr, keyedStr string) (*rate, *rate, error) {
+	anon, err := parseRate(anonStr)
+	if err != nil || keyedStr == "" {
+		return anon, anon, err
+	}
+	keyed, err := parseRate(keyedStr)
+	return anon, keyed, err
+}
+
+// refill returns how many tokens this rate adds back over d.
+func (r *rate) refill(d time.Duration) float64 {
+	return float64(d) * float64(r.limit) / float64(r.per)
+}
+
+// limitStore keeps a token bucket for each key.
+type limitStore interface {
+	// take removes a token from the bucket for this key at time t, or if it's empty returns how long until there will
+	// be one.
+	take(key string, r *rate, t time.Time) (time.Duration, error)
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time // when it'll be back to the limit, after which it can be forgotten
+}
+
+// memoryLimits keeps the buckets in this process, so each instance has it's own limits.
+type memoryLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+func newMemoryLimits() *memoryLimits {
+	return &memoryLimits{
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (m *memoryLimits) take(key string, r *rate, t time.Time) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// forget every bucket which has filled up again, since they're the same as a new one
+	if t.Sub(m.pruned) >= limitPruneEvery {
+		for k, b := range m.buckets {
+			if !t.Before(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.pruned = t
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(r.limit), updated: t}
+		m.buckets[key] = b
+	}
+	if t.After(b.updated) {
+		b.tokens += r.refill(t.Sub(b.updated))
+		if b.tokens > float64(r.limit) {
+			b.tokens = float64(r.limit)
+		}
+		b.updated = t
+	}
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(r.per) / float64(r.limit)), nil
+	}
+	b.tokens--
+	b.full = t.Add(time.Duration((float64(r.limit) - b.tokens) * float64(r.per) / float64(r.limit)))
+	return 0, nil
+}
+
+// the same as memoryLimits.take(), but atomically in Redis with times in milliseconds
+var takeScript = redis.NewScript(1, `
+local limit = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or limit
+local updated = tonumber(bucket[2]) or now
+if now > updated then
+  tokens = math.min(limit, tokens + (now - updated) * limit / per)
+  updated = now
+end
+
+if tokens < 1 then
+  return math.ceil((1 - tokens) * per / limit)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens - 1), 'updated', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], per)
+return 0
+`)
+
+// redisLimits keeps the buckets in Redis, so they are shared by every instance.
+type redisLimits struct {
+	pool *redis.Pool
+}
+
+func (l *redisLimits) take(key string, r *rate, t time.Time) (time.Duration, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+
+	ms, err := redis.Int64(takeScript.Do(conn, "ratelimit:"+key, r.limit, r.per.Milliseconds(), t.UnixNano()/int64(time.Millisecond)))
+	return time.Duration(ms) * time.Millisecond, err
+}
+
+// trustedProxies are the proxies (such as Caddy) whose X-Forwarded-For header we believe.
+type trustedProxies []*net.IPNet
+
+// parseTrustedProxies parses a comma separated list of IP addresses and CIDRs.
+func parseTrustedProxies(str string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0)
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, ErrInvalidTrustedProxy
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			part += "/" + strconv.Itoa(bits)
+		}
+
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, ErrInvalidTrustedProxy
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(ip net.IP) bool {
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp returns the address of whoever made this request. If it came through trusted proxies then it's the last
+// address in X-Forwarded-For which isn't one of them, since anything before that could have been made up by the
+// client.
+func (p trustedProxies) clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.contains(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !p.contains(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+// rateLimiter limits how fast each client can make requests, by their API key if they have one or else their IP.
+type rateLimiter struct {
+	store   limitStore
+	keys    apiKeys
+	proxies trustedProxies
+}
+
+func newRateLimiter(store limitStore, keys apiKeys, proxies trustedProxies) *rateLimiter {
+	return &rateLimiter{
+		store:   store,
+		keys:    keys,
+		proxies: proxies,
+	}
+}
+
+// limit returns the middleware which limits these routes (using their own buckets, called name) to anon for anonymous
+// clients and keyed for those with an API key, where a nil rate means no limit. Anything over gets a 429 saying when
+// to try again.
+func (l *rateLimiter) limit(name string, anon, keyed *rate) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := anon
+			bucket := name + ":ip:" + l.proxies.clientIp(r)
+			if key := l.keys.find(r); key != "" {
+				// the key itself is a secret, so the bucket is named after it's hash
+				sum := sha256.Sum256([]byte(key))
+				limit = keyed
+				bucket = name + ":key:" + hex.EncodeToString(sum[:8])
+			}
+			if limit == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			wait, err := l.store.take(bucket, limit, now())
+			if err != nil {
+				// better to let everyone through than nobody
+				log.Printf("ratelimit: %s\n", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			if wait <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			lgr := logger.LogFromRequest(r)
+			lgr.WithField("RateLimit", name)
+			lgr.Print("rate-limited")
+
+			w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				sendApiError(w, http.StatusTooManyRequests, "rate-limited", ErrRateLimited)
+				return
+			}
+			http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		str  string
+		want *rate
+	}{
+		{"", nil},
+		{"off", nil},
+		{"30/m", &rate{30, time.Minute}},
+		{"5/s", &rate{5, time.Second}},
+		{"100/10m", &rate{100, 10 * time.Minute}},
+	}
+	for _, test := range tests {
+		got, err := parseRate(test.str)
+		if err != nil || (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("parseRate(%q) = %+v, %v, want %+v", test.str, got, err, test.want)
+		}
+	}
+
+	for _, str := range []string{"30", "0/m", "x/m", "30/fortnight", "30/-1m"} {
+		if _, err := parseRate(str); err != ErrInvalidRate {
+			t.Errorf("parseRate(%q): got %v, want ErrInvalidRate", str, err)
+		}
+	}
+
+	anon, keyed, err := parseRates("10/m", "")
+	if err != nil || anon != keyed {
+		t.Errorf("keyed defaults to anon: got %+v, %+v, %v", anon, keyed, err)
+	}
+}
+
+func TestMemoryLimits(t *testing.T) {
+	limits := newMemoryLimits()
+	r := &rate{limit: 2, per: time.Minute}
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		key  string
+		t    time.Time
+		wait time.Duration
+	}{
+		{"a", t0, 0},
+		{"a", t0, 0},
+		{"a", t0, 30 * time.Second},
+		{"b", t0, 0}, // everyone has their own bucket
+		{"a", t0.Add(10 * time.Second), 20 * time.Second},
+		{"a", t0.Add(30 * time.Second), 0},
+		{"a", t0.Add(30 * time.Second), 30 * time.Second},
+	}
+	for i, test := range tests {
+		wait, err := limits.take(test.key, r, test.t)
+		if err != nil || wait != test.wait {
+			t.Errorf("take %d: got %s, %v, want %s", i, wait, err, test.wait)
+		}
+	}
+
+	// full buckets are forgotten
+	limits.take("c", r, t0.Add(time.Hour))
+	if len(limits.buckets) != 1 {
+		t.Errorf("got %d buckets, want 1", len(limits.buckets))
+	}
+}
+
+func TestClientIp(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTrustedProxies("not-an-ip"); err != ErrInvalidTrustedProxy {
+		t.Errorf("got %v, want ErrInvalidTrustedProxy", err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"203.0.113.9:1234", "198.51.100.1", "203.0.113.9"},
+		{"192.0.2.1:1234", "198.51.100.1", "198.51.100.1"},
+		{"192.0.2.1:1234", "6.6.6.6, 198.51.100.1, 10.1.2.3", "198.51.100.1"},
+		{"192.0.2.1:1234", "", "192.0.2.1"},
+		{"192.0.2.1:1234", "garbage", "192.0.2.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := proxies.clientIp(r); got != test.want {
+			t.Errorf("%s via %q: got %s, want %s", test.remoteAddr, test.forwarded, got, test.want)
+		}
+	}
+}
+
+func TestLimitApiThroughMux(t *testing.T) {
+	limiter := newRateLimiter(newMemoryLimits(), apiKeys{}, trustedProxies{})
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// mounted just like in main()
+	m := newTestMux()
+	m.Use("/api", limiter.limit("api", &rate{limit: 1, per: time.Minute}, nil))
+	m.Get("/api/v1/urls", ok)
+	m.Get("/api/v1/urls/:id", ok)
+	m.Get("/:id", ok)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/api/v1/urls", http.StatusOK},
+		{"/api/v1/urls/abc", http.StatusTooManyRequests},
+		{"/api/v1/urls", http.StatusTooManyRequests},
+		{"/abc", http.StatusOK},
+		{"/abc", http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", test.path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("GET %s: got %d, want %d", test.path, rec.Code, test.status)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("GET %s: no Retry-After", test.path)
+		}
+	}
+}
+
+func TestLimitKeyedClients(t *testing.T) {
+	keys := apiKeys{"secret"}
+	limiter := newRateLimiter(newMemoryLimits(), keys, trustedProxies{})
+	limit := limiter.limit("create", &rate{limit: 1, per: time.Minute}, nil)
+	h := limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("POST", "/new", nil)
+		req.Header.Set("X-Api-Key", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("request %d with a key: got %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRedirectStatus suits links which are tracked and can be edited, since browsers don't cache a 302.
+const defaultRedirectStatus = http.StatusFound
+
+// permanentRedirectMaxAge is the longest we let a browser cache a permanent redirect for, so that a changed, disabled
+// or taken down destination takes effect soon after and most hits are still counted.
+const permanentRedirectMaxAge = 5 * time.Minute
+
+var ErrInvalidRedirectStatus = errors.New("Redirect status must be one of 301, 302, 307 or 308")
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// parseRedirectStatus parses and validates a redirect status given as a string, such as from a form or the environment.
+func parseRedirectStatus(str string) (int, error) {
+	status, err := strconv.Atoi(str)
+	if err != nil || !isRedirectStatus(status) {
+		return 0, ErrInvalidRedirectStatus
+	}
+	return status, nil
+}
+
+// redirectStatus returns the status this ShortUrl redirects with, which is the instance default unless it has it's own.
+func (s *ShortUrl) redirectStatus(def int) int {
+	if s.RedirectStatus != 0 {
+		return s.RedirectStatus
+	}
+	return def
+}
+
+// redirectCacheControl returns the Cache-Control header to send with this redirect. Temporary redirects are never
+// cached so that every click is counted, and neither are links which run out of hits or depend on their rules or
+// variants.
+// Permanent redirects are cached for a short time (and never past their expiry), and only privately, since a shared
+// cache would keep serving them after a takedown and hide every hit behind it.
+func redirectCacheControl(s *ShortUrl, status int, t time.Time) string {
+	if status == http.StatusFound || status == http.StatusTemporaryRedirect || s.MaxHits > 0 || len(s.Rules) > 0 ||
+		len(s.Variants) > 0 {
+		return "private, no-cache"
+	}
+
+	maxAge := permanentRedirectMaxAge
+	if s.ExpiresAt != nil && s.ExpiresAt.Sub(t) < maxAge {
+		maxAge = s.ExpiresAt.Sub(t)
+	}
+
+	return fmt.Sprintf("private, max-age=%d", int(maxAge/time.Second))
+}
+
+// redirectTo sends the redirect to dest for this ShortUrl along with a matching Cache-Control header.
+func redirectTo(w http.ResponseWriter, r *http.Request, dest string, s *ShortUrl, def int, t time.Time) {
+	status := s.redirectStatus(def)
+	w.Header().Set("Cache-Control", redirectCacheControl(s, status, t))
+	http.Redirect(w, r, dest, status)
+}
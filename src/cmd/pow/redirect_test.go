@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRedirectStatus(t *testing.T) {
+	for _, str := range []string{"301", "302", "307", "308"} {
+		if _, err := parseRedirectStatus(str); err != nil {
+			t.Errorf("parseRedirectStatus(%q): got %v", str, err)
+		}
+	}
+	for _, str := range []string{"", "200", "303", "abc"} {
+		if _, err := parseRedirectStatus(str); err != ErrInvalidRedirectStatus {
+			t.Errorf("parseRedirectStatus(%q): got %v, want ErrInvalidRedirectStatus", str, err)
+		}
+	}
+
+	_, err := newShortUrl(ApiNewUrl{Url: "https://example.com/", RedirectStatus: 303}, nil, now())
+	if fieldErr, ok := err.(*FieldError); !ok || fieldErr.Field != "redirect-status" {
+		t.Errorf("newShortUrl with 303: got %v", err)
+	}
+}
+
+func TestRedirectCacheControl(t *testing.T) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	soon := t0.Add(2 * time.Minute)
+
+	tests := []struct {
+		name     string
+		shortUrl ShortUrl
+		status   int
+		want     string
+	}{
+		{"302", ShortUrl{}, http.StatusFound, "private, no-cache"},
+		{"307", ShortUrl{}, http.StatusTemporaryRedirect, "private, no-cache"},
+		{"301", ShortUrl{}, http.StatusMovedPermanently, "private, max-age=300"},
+		{"308 expiring", ShortUrl{ExpiresAt: &soon}, http.StatusPermanentRedirect, "private, max-age=120"},
+		{"301 with max hits", ShortUrl{MaxHits: 5}, http.StatusMovedPermanently, "private, no-cache"},
+		{"301 with rules", ShortUrl{Rules: []Rule{{Url: "https://example.com/ios"}}}, http.StatusMovedPermanently, "private, no-cache"},
+		{"301 with variants", ShortUrl{Variants: []Variant{{Name: "A", Url: "https://example.com/a", Weight: 1}}}, http.StatusMovedPermanently, "private, no-cache"},
+		{"301 with password", ShortUrl{Password: "hash"}, http.StatusMovedPermanently, "private, max-age=300"},
+	}
+	for _, test := range tests {
+		if got := redirectCacheControl(&test.shortUrl, test.status, t0); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRedirectTo(t *testing.T) {
+	shortUrl := ShortUrl{Url: "https://example.com/"}
+
+	rec := httptest.NewRecorder()
+	redirectTo(rec, httptest.NewRequest("GET", "/abc", nil), shortUrl.Url, &shortUrl, defaultRedirectStatus, now())
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != shortUrl.Url {
+		t.Errorf("default: got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	shortUrl.RedirectStatus = http.StatusPermanentRedirect
+	rec = httptest.NewRecorder()
+	redirectTo(rec, httptest.NewRequest("GET", "/abc", nil), shortUrl.Url, &shortUrl, defaultRedirectStatus, now())
+	if rec.Code != http.StatusPermanentRedirect {
+		t.Errorf("own status: got %d, want %d", rec.Code, http.StatusPermanentRedirect)
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // so timezones work even without zoneinfo on the server
+)
+
+const rulesMax = 20
+
+const platformIos = "ios"
+const platformAndroid = "android"
+const platformDesktop = "desktop"
+
+var (
+	ErrTooManyRules      = errors.New("A Short URL can have at most 20 rules")
+	ErrRuleNoConditions  = errors.New("At least one condition is required")
+	ErrRulePlatform      = errors.New("Platforms must be ios, android or desktop")
+	ErrRuleLanguage      = errors.New("Languages must be language tags such as en or fr-CA")
+	ErrRuleDay           = errors.New("Days must be Mon, Tue, Wed, Thu, Fri, Sat or Sun")
+	ErrRuleTime          = errors.New("Times must be given as 15:04 and come in a StartTime/EndTime pair")
+	ErrRuleDate          = errors.New("Dates must be given as 2006-01-02")
+	ErrRuleTimezone      = errors.New("Timezone must be a known timezone such as Europe/London")
+	ErrRuleReferrer      = errors.New("Referrers must be hostnames such as example.com")
+	ErrRuleDateBackwards = errors.New("StartDate must not be after EndDate")
+)
+
+var languageRegExp = regexp.MustCompile(`^[a-zA-Z]{1,8}(-[a-zA-Z0-9]{1,8})*$`)
+var hostnameRegExp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+var ruleDays = []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+
+// Rule sends visitors to a different Url when all of it's conditions match. Where a condition has a list, any one of
+// them matching is enough. Times and dates are in Timezone (default UTC), where the StartTime is included but the
+// EndTime is not, and a window such as 22:00 to 06:00 wraps over midnight. Both dates are included.
+type Rule struct {
+	Url       string
+	Platforms []string `json:",omitempty"` // ios, android or desktop (which is anything else)
+	Languages []string `json:",omitempty"` // e.g. "en" also matches "en-GB", but "en-GB" doesn't match "en"
+	Days      []string `json:",omitempty"` // Mon, Tue, ...
+	StartTime string   `json:",omitempty"` // 15:04
+	EndTime   string   `json:",omitempty"`
+	StartDate string   `json:",omitempty"` // 2006-01-02
+	EndDate   string   `json:",omitempty"`
+	Timezone  string   `json:",omitempty"`
+	Referrers []string `json:",omitempty"` // hostnames, which include their subdomains
+}
+
+// visitor is everything about a request which rules can match against.
+type visitor struct {
+	platform string
+	language string
+	referrer string
+	t        time.Time
+}
+
+func newVisitor(r *http.Request, t time.Time) *visitor {
+	v := visitor{
+		platform: platformFromUserAgent(r.UserAgent()),
+		language: preferredLanguage(r.Header.Get("Accept-Language")),
+		t:        t,
+	}
+	if u, err := url.Parse(r.Referer()); err == nil {
+		v.referrer = strings.ToLower(u.Hostname())
+	}
+	return &v
+}
+
+// platformFromUserAgent works out the platform from the User-Agent, where anything not iOS or Android is desktop.
+func platformFromUserAgent(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPad") || strings.Contains(ua, "iPod"):
+		return platformIos
+	case strings.Contains(ua, "Android"):
+		return platformAndroid
+	}
+	return platformDesktop
+}
+
+// preferredLanguage returns the visitor's most preferred language (in lowercase) from the Accept-Language header.
+func preferredLanguage(header string) string {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	langs := make([]lang, 0)
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			langs = append(langs, lang{tag, q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+	return langs[0].tag
+}
+
+// validateRules checks each rule and returns them with their Urls and conditions normalised.
+func validateRules(rules []Rule) ([]Rule, error) {
+	if len(rules) > rulesMax {
+		return nil, ErrTooManyRules
+	}
+
+	valid := make([]Rule, 0, len(rules))
+	for i, rule := range rules {
+		if err := validateRule(&rule); err != nil {
+			return nil, fmt.Errorf("Rule %d: %s", i+1, err)
+		}
+		valid = append(valid, rule)
+	}
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	return valid, nil
+}
+
+func validateRule(rule *Rule) error {
+	u, err := validateUrl(rule.Url)
+	if err != nil {
+		return err
+	}
+	rule.Url = u.String()
+
+	if len(rule.Platforms) == 0 && len(rule.Languages) == 0 && len(rule.Days) == 0 && rule.StartTime == "" &&
+		rule.EndTime == "" && rule.StartDate == "" && rule.EndDate == "" && len(rule.Referrers) == 0 {
+		return ErrRuleNoConditions
+	}
+
+	for i, platform := range rule.Platforms {
+		platform = strings.ToLower(platform)
+		if platform != platformIos && platform != platformAndroid && platform != platformDesktop {
+			return ErrRulePlatform
+		}
+		rule.Platforms[i] = platform
+	}
+
+	for i, lang := range rule.Languages {
+		if !languageRegExp.MatchString(lang) {
+			return ErrRuleLanguage
+		}
+		rule.Languages[i] = strings.ToLower(lang)
+	}
+
+	for _, day := range rule.Days {
+		if !containsString(ruleDays, day) {
+			return ErrRuleDay
+		}
+	}
+
+	if (rule.StartTime == "") != (rule.EndTime == "") {
+		return ErrRuleTime
+	}
+	if rule.StartTime != "" {
+		// normalised so they can be compared as strings
+		start, err := time.Parse("15:04", rule.StartTime)
+		if err != nil {
+			return ErrRuleTime
+		}
+		end, err := time.Parse("15:04", rule.EndTime)
+		if err != nil {
+			return ErrRuleTime
+		}
+		rule.StartTime = start.Format("15:04")
+		rule.EndTime = end.Format("15:04")
+	}
+
+	for _, date := range []string{rule.StartDate, rule.EndDate} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return ErrRuleDate
+		}
+	}
+	if rule.StartDate != "" && rule.EndDate != "" && rule.StartDate > rule.EndDate {
+		return ErrRuleDateBackwards
+	}
+
+	if rule.Timezone != "" {
+		if _, err := time.LoadLocation(rule.Timezone); err != nil {
+			return ErrRuleTimezone
+		}
+	}
+
+	for i, host := range rule.Referrers {
+		host = strings.ToLower(strings.TrimPrefix(host, "www."))
+		if !hostnameRegExp.MatchString(host) {
+			return ErrRuleReferrer
+		}
+		rule.Referrers[i] = host
+	}
+
+	return nil
+}
+
+// matches tells you whether every condition of this rule matches this visitor.
+func (rule *Rule) matches(v *visitor) bool {
+	if len(rule.Platforms) > 0 && !containsString(rule.Platforms, v.platform) {
+		return false
+	}
+
+	if len(rule.Languages) > 0 {
+		found := false
+		for _, lang := range rule.Languages {
+			if v.language == lang || strings.HasPrefix(v.language, lang+"-") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(rule.Referrers) > 0 {
+		found := false
+		for _, host := range rule.Referrers {
+			if v.referrer == host || strings.HasSuffix(v.referrer, "."+host) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	// everything else is about the time where the rule is
+	loc := time.UTC
+	if rule.Timezone != "" {
+		if l, err := time.LoadLocation(rule.Timezone); err == nil {
+			loc = l
+		}
+	}
+	t := v.t.In(loc)
+
+	if len(rule.Days) > 0 && !containsString(rule.Days, t.Format("Mon")) {
+		return false
+	}
+
+	date := t.Format("2006-01-02")
+	if rule.StartDate != "" && date < rule.StartDate {
+		return false
+	}
+	if rule.EndDate != "" && date > rule.EndDate {
+		return false
+	}
+
+	if rule.StartTime != "" {
+		now := t.Format("15:04")
+		if rule.StartTime <= rule.EndTime {
+			if now < rule.StartTime || now >= rule.EndTime {
+				return false
+			}
+		} else if now < rule.StartTime && now >= rule.EndTime {
+			// wraps over midnight
+			return false
+		}
+	}
+
+	return true
+}
+
+// Conditions describes each of this rule's conditions, for showing on the preview page.
+func (rule Rule) Conditions() []string {
+	conds := make([]string, 0)
+	if len(rule.Platforms) > 0 {
+		conds = append(conds, "platform is "+strings.Join(rule.Platforms, " or "))
+	}
+	if len(rule.Languages) > 0 {
+		conds = append(conds, "language is "+strings.Join(rule.Languages, " or "))
+	}
+	if len(rule.Referrers) > 0 {
+		conds = append(conds, "referred from "+strings.Join(rule.Referrers, " or "))
+	}
+
+	tz := rule.Timezone
+	if tz == "" {
+		tz = "UTC"
+	}
+	if len(rule.Days) > 0 {
+		conds = append(conds, "on "+strings.Join(rule.Days, ", ")+" ("+tz+")")
+	}
+	if rule.StartTime != "" {
+		conds = append(conds, "from "+rule.StartTime+" until "+rule.EndTime+" ("+tz+")")
+	}
+	if rule.StartDate != "" {
+		conds = append(conds, "on or after "+rule.StartDate+" ("+tz+")")
+	}
+	if rule.EndDate != "" {
+		conds = append(conds, "on or before "+rule.EndDate+" ("+tz+")")
+	}
+
+	return conds
+}
+
+// target returns where this visitor should go, which is the Url of the first matching rule, or the ShortUrl's own Url if
+// none match. It also tells you whether a rule matched.
+func (s *ShortUrl) target(v *visitor) (string, bool) {
+	for i := range s.Rules {
+		if s.Rules[i].matches(v) {
+			return s.Rules[i].Url, true
+		}
+	}
+	return s.Url, false
+}
+
+// destinations returns every Url this ShortUrl might redirect to.
+func (s *ShortUrl) destinations() []string {
+	dests := []string{s.Url}
+	for _, rule := range s.Rules {
+		dests = append(dests, rule.Url)
+	}
+	for _, variant := range s.Variants {
+		dests = append(dests, variant.Url)
+	}
+	if s.DeepLink != nil {
+		for _, store := range []string{s.DeepLink.IosStore, s.DeepLink.AndroidStore} {
+			if store != "" {
+				dests = append(dests, store)
+			}
+		}
+	}
+	if s.Template != "" {
+		// the host can't change, so the template without it's placeholders is enough to check
+		if expanded, err := expandTemplate(s.Template, "", ""); err == nil {
+			dests = append(dests, expanded)
+		}
+	}
+	return dests
+}
+
+func containsString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
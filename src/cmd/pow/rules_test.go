@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"fr-CA", "fr-ca"},
+		{"en;q=0.5, de;q=0.9, *;q=0.1", "de"},
+		{"en-GB,en;q=0.9", "en-gb"},
+		{"fr;q=0, es", "es"},
+	}
+	for _, test := range tests {
+		if got := preferredLanguage(test.header); got != test.want {
+			t.Errorf("preferredLanguage(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		err  error
+	}{
+		{Rule{Url: "https://example.com/"}, ErrRuleNoConditions},
+		{Rule{Url: "https://example.com/", Platforms: []string{"windows"}}, ErrRulePlatform},
+		{Rule{Url: "https://example.com/", Languages: []string{"en_GB"}}, ErrRuleLanguage},
+		{Rule{Url: "https://example.com/", Days: []string{"Monday"}}, ErrRuleDay},
+		{Rule{Url: "https://example.com/", StartTime: "09:00"}, ErrRuleTime},
+		{Rule{Url: "https://example.com/", StartTime: "9am", EndTime: "17:00"}, ErrRuleTime},
+		{Rule{Url: "https://example.com/", StartDate: "2026-13-01"}, ErrRuleDate},
+		{Rule{Url: "https://example.com/", StartDate: "2026-02-01", EndDate: "2026-01-01"}, ErrRuleDateBackwards},
+		{Rule{Url: "https://example.com/", Days: []string{"Mon"}, Timezone: "Mars/Olympus"}, ErrRuleTimezone},
+		{Rule{Url: "https://example.com/", Referrers: []string{"https://example.com/"}}, ErrRuleReferrer},
+	}
+	for _, test := range tests {
+		_, err := validateRules([]Rule{test.rule})
+		if err == nil || !strings.HasSuffix(err.Error(), test.err.Error()) {
+			t.Errorf("%+v: got %v, want %v", test.rule, err, test.err)
+		}
+	}
+
+	rules, err := validateRules([]Rule{{Url: "https://example.com/", Platforms: []string{"iOS"}, Referrers: []string{"www.News.example"}, StartTime: "9:05", EndTime: "17:00"}})
+	if err != nil || rules[0].Platforms[0] != "ios" || rules[0].Referrers[0] != "news.example" || rules[0].StartTime != "09:05" {
+		t.Errorf("normalised: got %+v, %v", rules, err)
+	}
+
+	if _, err := validateRules(make([]Rule, rulesMax+1)); err != ErrTooManyRules {
+		t.Errorf("too many: got %v, want ErrTooManyRules", err)
+	}
+}
+
+func TestRuleTarget(t *testing.T) {
+	// Friday 16th October 2026, 23:30 in London
+	t0 := time.Date(2026, 10, 16, 22, 30, 0, 0, time.UTC)
+
+	shortUrl := ShortUrl{
+		Url: "https://example.com/default",
+		Rules: []Rule{
+			{Url: "https://example.com/ios-fr", Platforms: []string{"ios"}, Languages: []string{"fr"}},
+			{Url: "https://example.com/android", Platforms: []string{"android"}},
+			{Url: "https://example.com/news", Referrers: []string{"news.example"}},
+			{Url: "https://example.com/late", StartTime: "22:00", EndTime: "06:00", Timezone: "Europe/London"},
+			{Url: "https://example.com/weekend", Days: []string{"Sat", "Sun"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		ua       string
+		language string
+		referrer string
+		t        time.Time
+		want     string
+	}{
+		{"iPhone in French", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0)", "fr-CA", "", t0, "https://example.com/ios-fr"},
+		{"iPhone in English", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0)", "en", "", t0, "https://example.com/late"},
+		{"Android", "Mozilla/5.0 (Linux; Android 14)", "fr", "", t0, "https://example.com/android"},
+		{"from news", "Mozilla/5.0 (X11; Linux x86_64)", "", "https://www.news.example/story", t0, "https://example.com/news"},
+		{"late in London", "Mozilla/5.0 (X11; Linux x86_64)", "", "", t0, "https://example.com/late"},
+		{"Saturday lunchtime", "Mozilla/5.0 (X11; Linux x86_64)", "", "", t0.Add(14 * time.Hour), "https://example.com/weekend"},
+		{"Friday lunchtime", "Mozilla/5.0 (X11; Linux x86_64)", "", "", t0.Add(-10 * time.Hour), "https://example.com/default"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/abc", nil)
+		r.Header.Set("User-Agent", test.ua)
+		r.Header.Set("Accept-Language", test.language)
+		r.Header.Set("Referer", test.referrer)
+
+		got, ruled := shortUrl.target(newVisitor(r, test.t))
+		if got != test.want || ruled != (test.want != shortUrl.Url) {
+			t.Errorf("%s: got %s (%v), want %s", test.name, got, ruled, test.want)
+		}
+	}
+}
+
+func TestRulesBlocked(t *testing.T) {
+	store := newTestStore(t)
+	if err := store.putBlock(&Block{Pattern: "bad.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	shortUrl := ShortUrl{Url: "https://example.com/", Rules: []Rule{{Url: "https://bad.example/", Platforms: []string{"ios"}}}}
+	if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", false); err != ErrDestinationBlocked {
+		t.Errorf("got %v, want ErrDestinationBlocked", err)
+	}
+}
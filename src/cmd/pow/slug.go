@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const slugMinLen = 3
+const slugMaxLen = 64
+
+var (
+	ErrSlugInvalidChars = errors.New("Slug must start with a letter or number and contain only letters, numbers, dashes and underscores")
+	ErrSlugTooShort     = errors.New("Slug must be at least 3 characters long")
+	ErrSlugTooLong      = errors.New("Slug must be at most 64 characters long")
+	ErrSlugReserved     = errors.New("Slug is reserved, please choose another")
+	ErrSlugTaken        = errors.New("Slug has already been taken, please choose another")
+)
+
+var slugRegExp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// reservedSlugs can't be chosen since they would shadow (or be confused with) our own routes.
+var reservedSlugs = map[string]bool{
+	"-":           true,
+	"api":         true,
+	"favicon.ico": true,
+	"new":         true,
+	"robots.txt":  true,
+	"s":           true,
+}
+
+func validateSlug(slug string) error {
+	if len(slug) < slugMinLen {
+		return ErrSlugTooShort
+	}
+	if len(slug) > slugMaxLen {
+		return ErrSlugTooLong
+	}
+
+	// since we're checking the charset, this also means the '+' preview suffix can never be part of a slug
+	if !slugRegExp.MatchString(slug) {
+		return ErrSlugInvalidChars
+	}
+
+	if reservedSlugs[strings.ToLower(slug)] {
+		return ErrSlugReserved
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidateSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		err  error
+	}{
+		{"launch-2026", nil},
+		{"a_b", nil},
+		{"ab", ErrSlugTooShort},
+		{"abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabcdefghijklm", ErrSlugTooLong},
+		{"-launch", ErrSlugInvalidChars},
+		{"launch+", ErrSlugInvalidChars},
+		{"two words", ErrSlugInvalidChars},
+		{"new", ErrSlugReserved},
+		{"API", ErrSlugReserved},
+	}
+	for _, test := range tests {
+		if err := validateSlug(test.slug); err != test.err {
+			t.Errorf("validateSlug(%q) = %v, want %v", test.slug, err, test.err)
+		}
+	}
+}
+
+func TestApiCreateWithSlug(t *testing.T) {
+	m := newTestApi(t)
+
+	created := ApiUrl{}
+	rec := apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.com/","Slug":"launch"}`, &created)
+	if rec.Code != http.StatusCreated || created.Id != "launch" {
+		t.Fatalf("got %d with Id %q, want %d with Id launch", rec.Code, created.Id, http.StatusCreated)
+	}
+
+	apiErr := ApiError{}
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.org/","Slug":"launch"}`, &apiErr)
+	if rec.Code != http.StatusConflict || apiErr.Code != "slug-taken" {
+		t.Errorf("taken: got %d %+v, want %d slug-taken", rec.Code, apiErr, http.StatusConflict)
+	}
+
+	rec = apiRequest(t, m, "POST", "/api/v1/urls", `{"Url":"https://example.org/","Slug":"robots.txt"}`, &apiErr)
+	if rec.Code != http.StatusBadRequest || apiErr.Code != "invalid-slug" {
+		t.Errorf("reserved: got %d %+v, want %d invalid-slug", rec.Code, apiErr, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// SQLite is the default POW_STORE_DRIVER, but since it needs cgo (and a C compiler) it's only built in with `-tags
+// sqlite`. Any other database/sql driver can be built in the same way, by importing it in a file like this one.
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// sqlEachBatch is how many keys sqlTx.each reads at a time.
+const sqlEachBatch = 100
+
+// sqlSequenceTable keeps the sequence of each bucket, since not every database has sequences.
+const sqlSequenceTable = "sequence"
+
+// sqlStore keeps each bucket in a table of the same name, with a key and it's value, using database/sql. It works with
+// any driver which has been built into the binary (see sqlite.go) whose database can have TEXT primary keys which sort
+// byte by byte, just like Bolt's keys, such as SQLite or Postgres.
+type sqlStore struct {
+	db       *sql.DB
+	numbered bool // whether placeholders are $1, $2 rather than ?, which also means Postgres
+}
+
+// newSqlStore opens the database with this driver and creates any tables it doesn't have yet.
+func newSqlStore(driver, dsn string) (*sqlStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqlStore{
+		db:       db,
+		numbered: driver == "postgres" || driver == "pgx",
+	}
+	for _, table := range append(storeBuckets, sqlSequenceTable) {
+		_, err := db.Exec(s.createTable(table))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// createTable returns the statement to create this table. Postgres sorts TEXT using the database's locale unless told
+// otherwise, so the keys are given the "C" collation to sort them by their bytes instead. SQLite always does.
+func (s *sqlStore) createTable(table string) string {
+	key := "k TEXT PRIMARY KEY"
+	if s.numbered {
+		key = `k TEXT COLLATE "C" PRIMARY KEY`
+	}
+	return "CREATE TABLE IF NOT EXISTS " + table + " (" + key + ", v TEXT NOT NULL)"
+}
+
+// bind rewrites the ? placeholders in this query for drivers which number them instead.
+func (s *sqlStore) bind(query string) string {
+	if !s.numbered {
+		return query
+	}
+	parts := strings.Split(query, "?")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = "$" + strconv.Itoa(i) + parts[i]
+	}
+	return strings.Join(parts, "")
+}
+
+func (s *sqlStore) view(fn func(tx storeTx) error) error {
+	return s.run(false, fn)
+}
+
+func (s *sqlStore) update(fn func(tx storeTx) error) error {
+	return s.run(true, fn)
+}
+
+// run calls fn within a transaction, which is committed if it returns nil and rolled back otherwise.
+func (s *sqlStore) run(writable bool, fn func(tx storeTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(&sqlTx{store: s, tx: tx, writable: writable}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) close() error {
+	return s.db.Close()
+}
+
+type sqlTx struct {
+	store    *sqlStore
+	tx       *sql.Tx
+	writable bool
+}
+
+func (t *sqlTx) get(bucket, key string) ([]byte, error) {
+	var value string
+	err := t.tx.QueryRow(t.store.bind("SELECT v FROM "+bucket+" WHERE k = ?"), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(value), nil
+}
+
+// put replaces any value already there. Since every database has it's own way of doing an upsert, it's a delete then
+// an insert.
+func (t *sqlTx) put(bucket, key string, value []byte) error {
+	if err := t.del(bucket, key); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec(t.store.bind("INSERT INTO "+bucket+" (k, v) VALUES (?, ?)"), key, string(value))
+	return err
+}
+
+func (t *sqlTx) del(bucket, key string) error {
+	if !t.writable {
+		return ErrReadOnlyTx
+	}
+	_, err := t.tx.Exec(t.store.bind("DELETE FROM "+bucket+" WHERE k = ?"), key)
+	return err
+}
+
+// each reads the keys in batches, so that fn can use the transaction too (which some drivers can't do while rows are
+// still being read).
+func (t *sqlTx) each(bucket, after string, fn func(key string, value []byte) error) error {
+	for {
+		keys, values, err := t.batch(bucket, after)
+		if err != nil {
+			return err
+		}
+		for i, key := range keys {
+			if err := fn(key, values[i]); err != nil {
+				return err
+			}
+		}
+		if len(keys) < sqlEachBatch {
+			return nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// batch returns the next sqlEachBatch keys (and their values) in this bucket after the one given.
+func (t *sqlTx) batch(bucket, after string) ([]string, [][]byte, error) {
+	rows, err := t.tx.Query(t.store.bind("SELECT k, v FROM "+bucket+" WHERE k > ? ORDER BY k LIMIT ?"), after, sqlEachBatch)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]string, 0, sqlEachBatch)
+	values := make([][]byte, 0, sqlEachBatch)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		values = append(values, []byte(value))
+	}
+	return keys, values, rows.Err()
+}
+
+func (t *sqlTx) nextSequence(bucket string) (uint64, error) {
+	str, err := getString(t, sqlSequenceTable, bucket)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	if str != "" {
+		n, err = strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+	}
+	n++
+	return n, putString(t, sqlSequenceTable, bucket, strconv.FormatUint(n, 10))
+}
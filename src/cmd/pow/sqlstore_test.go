@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestSqlStore opens a new SQLite store, which is removed after the test.
+func newTestSqlStore(t *testing.T) *sqlStore {
+	store, err := newSqlStore("sqlite3", filepath.Join(t.TempDir(), "pow.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.close() })
+	return store
+}
+
+func TestSqlStore(t *testing.T) {
+	testStore(t, newBucketStore(newTestSqlStore(t)))
+}
+
+func TestSqlStoreEach(t *testing.T) {
+	store := newTestSqlStore(t)
+
+	// more than one batch, and not in order
+	want := make([]string, 0)
+	err := store.update(func(tx storeTx) error {
+		for i := 0; i < sqlEachBatch*2+50; i++ {
+			key := fmt.Sprintf("%x", i*7919)
+			want = append(want, key)
+			if err := putString(tx, urlBucketNameStr, key, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(want)
+
+	got := make([]string, 0)
+	err = store.view(func(tx storeTx) error {
+		return each(tx, urlBucketNameStr, "", func(key string, value []byte) error {
+			if string(value) != key {
+				t.Errorf("%s: got %q", key, value)
+			}
+			got = append(got, key)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %d keys, want %d in order", len(got), len(want))
+	}
+}
+
+func TestSqlCreateTable(t *testing.T) {
+	if got := (&sqlStore{}).createTable("url"); got != "CREATE TABLE IF NOT EXISTS url (k TEXT PRIMARY KEY, v TEXT NOT NULL)" {
+		t.Errorf("got %q", got)
+	}
+	if got := (&sqlStore{numbered: true}).createTable("url"); got != `CREATE TABLE IF NOT EXISTS url (k TEXT COLLATE "C" PRIMARY KEY, v TEXT NOT NULL)` {
+		t.Errorf("numbered: got %q", got)
+	}
+}
+
+func TestSqlBind(t *testing.T) {
+	query := "SELECT v FROM url WHERE k = ? AND x = ?"
+	if got := (&sqlStore{}).bind(query); got != query {
+		t.Errorf("got %q", got)
+	}
+	if got := (&sqlStore{numbered: true}).bind(query); got != "SELECT v FROM url WHERE k = $1 AND x = $2" {
+		t.Errorf("numbered: got %q", got)
+	}
+}
@@ -5,12 +5,10 @@ import (
 	"log"
 	"time"
 
-	"github.com/boltdb/bolt"
-	"github.com/chilts/rod"
 	"github.com/garyburd/redigo/redis"
 )
 
-func incHits(pool *redis.Pool, id string) {
+func incHits(pool *redis.Pool, id, variant, campaign string) {
 	if pool == nil {
 		return
 	}
@@ -28,13 +26,89 @@ func incHits(pool *redis.Pool, id string) {
 	conn.Send("MULTI")
 	conn.Send("INCR", "count:"+datetime+":"+id)
 	conn.Send("SADD", "active:"+datetime, id)
+	if variant != "" {
+		// and variants:20060102-15:<id> is a hash of variant -> count
+		conn.Send("HINCRBY", "variants:"+datetime+":"+id, variant, 1)
+	}
+	if campaign != "" {
+		// and campaigns:20060102-15:<id> is a hash of campaign name -> count
+		conn.Send("HINCRBY", "campaigns:"+datetime+":"+id, campaign, 1)
+	}
 	_, err := conn.Do("EXEC")
 	if err != nil {
 		log.Printf("incHits: %s\n", err)
 	}
 }
 
-func stats(pool *redis.Pool, db *bolt.DB) {
+// addHits adds count hits in the hour starting at t to these stats.
+func addHits(stats *Stats, t time.Time, count int64) {
+	stats.Total += count
+	if stats.Daily == nil {
+		stats.Daily = make(map[string]int64)
+	}
+	stats.Daily[t.Format("20060102")] += count
+	if stats.Hourly == nil {
+		stats.Hourly = make(map[string]int64)
+	}
+	stats.Hourly[t.Format("15")] += count
+	if stats.DOTWly == nil {
+		stats.DOTWly = make(map[string]int64)
+	}
+	stats.DOTWly[t.Format("Mon")] += count
+}
+
+// addVariantHits adds the hits for each of these variants to these stats.
+func addVariantHits(stats *Stats, variants map[string]int64) {
+	for variant, n := range variants {
+		if stats.Variants == nil {
+			stats.Variants = make(map[string]int64)
+		}
+		stats.Variants[variant] += n
+	}
+}
+
+// saveHits adds count hits in the hour starting at t (and those for each variant) to this ShortUrl, and those for each
+// campaign to it's stats for that campaign, then marks the hour (as in "20060102-15:<id>") as processed. If it already
+// was, nothing is added and false is returned.
+func (s *bucketStore) saveHits(hour, id string, t time.Time, count int64, variants, campaigns map[string]int64) (bool, error) {
+	added := false
+	err := s.update(func(tx storeTx) error {
+		// firstly, let's see if these stats have already been processed
+		done, err := getString(tx, doneBucketNameStr, hour)
+		if err != nil {
+			return err
+		}
+		if done != "" {
+			return nil
+		}
+
+		// get the stats and increment the right slots
+		stats := Stats{}
+		err = getJson(tx, statsBucketNameStr, id, &stats)
+		if err != nil {
+			return err
+		}
+		addHits(&stats, t, count)
+		addVariantHits(&stats, variants)
+		err = putJson(tx, statsBucketNameStr, id, stats)
+		if err != nil {
+			return err
+		}
+
+		// each campaign gets the hits it was there for
+		err = addCampaignHitsTx(tx, id, t, campaigns)
+		if err != nil {
+			return err
+		}
+
+		// and say we're done
+		added = true
+		return putString(tx, doneBucketNameStr, hour, now().Format("20060102-150405.000000000"))
+	})
+	return added, err
+}
+
+func stats(pool *redis.Pool, store Store) {
 	if pool == nil {
 		log.Printf("Not setting up stats collection from Redis")
 		return
@@ -46,11 +120,11 @@ func stats(pool *redis.Pool, db *bolt.DB) {
 	ticker := time.NewTicker(duration)
 	for t := range ticker.C {
 		log.Println("Tick at", t)
-		processRandStat(pool, db)
+		processRandStat(pool, store)
 	}
 }
 
-func processRandStat(pool *redis.Pool, db *bolt.DB) {
+func processRandStat(pool *redis.Pool, store Store) {
 	// get a connection
 	conn := pool.Get()
 	defer conn.Close()
@@ -64,7 +138,7 @@ func processRandStat(pool *redis.Pool, db *bolt.DB) {
 	// get one random ID
 	id, err := redis.String(conn.Do("SRANDMEMBER", "active:"+datetime))
 	if err != nil {
-		log.Printf(err.Error())
+		log.Print(err)
 		return
 	}
 
@@ -74,63 +148,38 @@ func processRandStat(pool *redis.Pool, db *bolt.DB) {
 	hour := datetime + ":" + id
 	count, err := redis.Int64(conn.Do("GET", "count:"+hour))
 	if err != nil {
-		log.Printf(err.Error())
+		log.Print(err)
 		return
 	}
 	fmt.Printf("* count=%d\n", count)
 
-	// put these stats into Bolt
-	stats := Stats{}
-	err = db.Update(func(tx *bolt.Tx) error {
-		// firstly, let's see if these stats have already been processed
-		done, err := rod.GetString(tx, doneBucketNameStr, hour)
-		if err != nil {
-			return err
-		}
+	// and the hits for each variant, if there are any
+	variants, err := redis.Int64Map(conn.Do("HGETALL", "variants:"+hour))
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-		if done == "" {
-			fmt.Printf("not yet done\n")
-			// get the stats and increment the right slots
-			fmt.Printf("* 1 stats=%#v\n", stats)
-			rod.GetJson(tx, statsBucketNameStr, id, &stats)
-			fmt.Printf("* 2 stats=%#v\n", stats)
-			stats.Total += count
-			if stats.Daily == nil {
-				stats.Daily = make(map[string]int64)
-			}
-			stats.Daily[t.Format("20060102")] += count
-			if stats.Hourly == nil {
-				stats.Hourly = make(map[string]int64)
-			}
-			stats.Hourly[t.Format("15")] += count
-			if stats.DOTWly == nil {
-				stats.DOTWly = make(map[string]int64)
-			}
-			stats.DOTWly[t.Format("Mon")] += count
-			fmt.Printf("* 3 stats=%#v\n", stats)
-			//
-			err = rod.PutJson(tx, statsBucketNameStr, id, stats)
-			if err != nil {
-				return err
-			}
-			// and say we're done
-			err = rod.PutString(tx, doneBucketNameStr, hour, now().Format("20060102-150405.000000000"))
-			if err != nil {
-				return err
-			}
-		} else {
-			fmt.Printf("done:%s\n", done)
-		}
+	// and for each campaign, if there are any
+	campaigns, err := redis.Int64Map(conn.Do("HGETALL", "campaigns:"+hour))
+	if err != nil {
+		log.Print(err)
+		return
+	}
 
-		return nil
-	})
+	// put these stats into the store, unless they already have been
+	added, err := store.saveHits(hour, id, t, count, variants, campaigns)
 	if err != nil {
-		log.Printf(err.Error())
+		log.Print(err)
+	} else if !added {
+		fmt.Printf("already done\n")
 	}
 
 	// and finally, remove this hit from Redis
 	conn.Send("MULTI")
 	conn.Send("DEL", "count:"+hour)
+	conn.Send("DEL", "variants:"+hour)
+	conn.Send("DEL", "campaigns:"+hour)
 	conn.Send("SREM", "active:"+datetime, id)
 	_, err = conn.Do("EXEC")
 	if err != nil {
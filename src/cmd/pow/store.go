@@ -0,0 +1,330 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	ErrUnknownStore = errors.New("POW_STORE must be one of bolt, memory or sql")
+	ErrNoStoreDsn   = errors.New("POW_STORE_DSN must be set when POW_STORE is sql")
+	ErrReadOnlyTx   = errors.New("Nothing can be changed within a read-only transaction")
+)
+
+// errStop can be returned from the fn given to storeTx.each to stop early, in which case each returns nil.
+var errStop = errors.New("stop")
+
+// storeBuckets are every bucket the server uses, each of which is created when a kvStore is opened.
+var storeBuckets = []string{
+	urlBucketNameStr,
+	statsBucketNameStr,
+	doneBucketNameStr,
+	hitsBucketNameStr,
+	takedownBucketNameStr,
+	blockBucketNameStr,
+	destBucketNameStr,
+	campaignBucketNameStr,
+	tagBucketNameStr,
+	metaBucketNameStr,
+	healthBucketNameStr,
+	goneBucketNameStr,
+}
+
+// Store is where everything is kept: the ShortUrls, their stats and the hours already counted into them, takedowns and
+// blocks, and what's known about each destination. Everything else only ever uses these, and never anything below.
+type Store interface {
+	// ShortUrls
+	createShortUrl(lists *blocklists, ids idGenerator, shortUrl *ShortUrl, slug string, reuse bool) (bool, error)
+	createShortUrls(lists *blocklists, ids idGenerator, pending []*pendingShortUrl) error
+	getShortUrl(id string) (*ShortUrl, error)
+	putShortUrl(shortUrl *ShortUrl) error
+	updateShortUrl(lists *blocklists, id string, fn func(*ShortUrl) error) (*ShortUrl, error)
+	deleteShortUrl(id string, t time.Time) error
+	listShortUrls(cursor string, limit int) ([]*ApiUrl, string, error)
+	searchShortUrls(q, tag string) ([]*ShortUrl, error)
+	listTags() (map[string]int, error)
+	reapExpired(t time.Time) (int, error)
+	getTombstone(id string) (*Tombstone, error)
+
+	// stats, hits and the hours which have already been counted
+	getStats(id string) (*Stats, error)
+	getHits(id string) (int64, error)
+	useHit(shortUrl *ShortUrl) (bool, error)
+	saveHits(hour, id string, t time.Time, count int64, variants, campaigns map[string]int64) (bool, error)
+	getCampaignStats(id, name string) (*Stats, error)
+
+	// takedowns and blocks
+	getTakedown(id string) (*Takedown, error)
+	putTakedown(takedown *Takedown) error
+	delTakedown(id string) error
+	listTakedowns() ([]*Takedown, error)
+	findBlock(str string) (*Block, error)
+	putBlock(block *Block) error
+	delBlock(pattern string) error
+	listBlocks() ([]*Block, error)
+
+	// what's known about each destination
+	getMeta(id string) (*Meta, error)
+	putMeta(id string, meta *Meta) error
+	getHealth(id string) ([]*Health, error)
+	putHealth(id string, healths []*Health) error
+	findDueHealthChecks(interval time.Duration, t time.Time, max int) ([]*ShortUrl, [][]*Health, error)
+	listBrokenLinks() ([]*BrokenLink, error)
+
+	close() error
+}
+
+// bucketStore is the only Store, which keeps everything in the storeBuckets of a kvStore. That way each kind of kvStore
+// behaves exactly the same, and only has to know how to keep the keys of a bucket in order.
+type bucketStore struct {
+	kvStore
+}
+
+func newBucketStore(kv kvStore) *bucketStore {
+	return &bucketStore{kv}
+}
+
+// kvStore keeps the keys and values of each bucket, and can be Bolt, memory or SQL (see openStore).
+type kvStore interface {
+	// view runs fn within a read-only transaction.
+	view(fn func(tx storeTx) error) error
+	// update runs fn within a read-write transaction, which is only committed if fn returns nil.
+	update(fn func(tx storeTx) error) error
+	close() error
+}
+
+// storeTx is a transaction on a kvStore.
+type storeTx interface {
+	// get returns the value for this key, or nil if there isn't one. It is only valid until the transaction ends.
+	get(bucket, key string) ([]byte, error)
+	put(bucket, key string, value []byte) error
+	del(bucket, key string) error
+	// each calls fn with every key in this bucket after the one given (or from the first if it's ""), in order,
+	// until fn returns an error. fn mustn't change this bucket, so collect the keys first if it needs to be.
+	each(bucket, after string, fn func(key string, value []byte) error) error
+	// nextSequence returns the next number in a sequence kept for this bucket, starting at 1.
+	nextSequence(bucket string) (uint64, error)
+}
+
+// getJson decodes the value for this key into v, leaving v alone if there isn't one.
+func getJson(tx storeTx, bucket, key string, v interface{}) error {
+	data, err := tx.get(bucket, key)
+	if err != nil || data == nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func putJson(tx storeTx, bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.put(bucket, key, data)
+}
+
+// getString returns the value for this key, or "" if there isn't one.
+func getString(tx storeTx, bucket, key string) (string, error) {
+	data, err := tx.get(bucket, key)
+	return string(data), err
+}
+
+func putString(tx storeTx, bucket, key, value string) error {
+	return tx.put(bucket, key, []byte(value))
+}
+
+// each calls fn for every key in this bucket, and stops quietly if fn returns errStop.
+func each(tx storeTx, bucket, after string, fn func(key string, value []byte) error) error {
+	err := tx.each(bucket, after, fn)
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+// openStore opens the Store kept in the kvStore named by kind (as in POW_STORE) at dsn, which for bolt is the file
+// (default "pow.db") and for sql is the data source for this driver. A memory one needs neither.
+func openStore(kind, dsn, driver string) (Store, error) {
+	var store kvStore
+	var err error
+
+	switch kind {
+	case "", "bolt":
+		if dsn == "" {
+			dsn = "pow.db"
+		}
+		store, err = openBoltStore(dsn)
+	case "memory":
+		store = newMemoryStore()
+	case "sql":
+		if dsn == "" {
+			return nil, ErrNoStoreDsn
+		}
+		if driver == "" {
+			driver = "sqlite3"
+		}
+		store, err = newSqlStore(driver, dsn)
+	default:
+		return nil, ErrUnknownStore
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the abusive URLs we used to delete here are now takedowns
+	err = store.update(func(tx storeTx) error {
+		return migrateLegacyTakedowns(tx, now())
+	})
+	if err != nil {
+		store.close()
+		return nil, err
+	}
+	return newBucketStore(store), nil
+}
+
+// openStoreFromEnv opens the Store configured by POW_STORE, POW_STORE_DSN and POW_STORE_DRIVER.
+func openStoreFromEnv() (Store, error) {
+	return openStore(os.Getenv("POW_STORE"), os.Getenv("POW_STORE_DSN"), os.Getenv("POW_STORE_DRIVER"))
+}
+
+// boltStore keeps each bucket in a Bolt bucket of the same name, just like it always has.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// openBoltStore opens (or creates) the Bolt database in this file and makes sure it has all of the storeBuckets.
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range storeBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return migrateTagBuckets(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// migrateTagBuckets moves the ids of each tag out of the bucket each tag used to have within the tag bucket, and into
+// the tag bucket itself (see tagKey).
+func migrateTagBuckets(tx *bolt.Tx) error {
+	b := tx.Bucket([]byte(tagBucketNameStr))
+
+	tags := make([][]byte, 0)
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			tags = append(tags, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		ids := make([]string, 0)
+		err := b.Bucket(tag).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := b.DeleteBucket(tag); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := b.Put([]byte(tagKey(string(tag), id)), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *boltStore) view(fn func(tx storeTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) update(fn func(tx storeTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) get(bucket, key string) ([]byte, error) {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil, nil
+	}
+	return b.Get([]byte(key)), nil
+}
+
+func (t boltTx) put(bucket, key string, value []byte) error {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), value)
+}
+
+func (t boltTx) del(bucket, key string) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+func (t boltTx) each(bucket, after string, fn func(key string, value []byte) error) error {
+	b := t.tx.Bucket([]byte(bucket))
+	if b == nil {
+		return nil
+	}
+
+	c := b.Cursor()
+	k, v := c.First()
+	if after != "" {
+		k, v = c.Seek([]byte(after))
+		if k != nil && string(k) == after {
+			k, v = c.Next()
+		}
+	}
+	for ; k != nil; k, v = c.Next() {
+		if err := fn(string(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t boltTx) nextSequence(bucket string) (uint64, error) {
+	b, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return 0, err
+	}
+	return b.NextSequence()
+}
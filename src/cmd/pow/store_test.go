@@ -0,0 +1,424 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testStore checks this Store behaves just like it does on every other kvStore.
+func testStore(t *testing.T, store Store) {
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	if shortUrl, err := store.getShortUrl("nope"); shortUrl != nil || err != nil {
+		t.Errorf("missing: got %+v, %v", shortUrl, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		shortUrl := ShortUrl{Url: fmt.Sprintf("https://example.com/%d", i), Tags: []string{"docs"}, MaxHits: 10}
+		if i == 1 {
+			shortUrl.Campaign = &Campaign{Name: "launch", Source: "news"}
+			shortUrl.Tags = []string{"docs", "team"}
+		}
+		if _, err := store.createShortUrl(noLists, testIds, &shortUrl, fmt.Sprintf("id%d", i), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shortUrl, err := store.getShortUrl("id1")
+	if err != nil || shortUrl == nil || shortUrl.Url != "https://example.com/1" || shortUrl.Campaign.Name != "launch" {
+		t.Errorf("get: got %+v, %v", shortUrl, err)
+	}
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "id1", false); err != ErrSlugTaken {
+		t.Errorf("taken: got %v, want ErrSlugTaken", err)
+	}
+
+	// pages of 2, in id order
+	got := make([]string, 0)
+	cursor := ""
+	for pages := 0; pages < 5; pages++ {
+		urls, next, err := store.listShortUrls(cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, apiUrl := range urls {
+			got = append(got, apiUrl.Id)
+			if apiUrl.Stats == nil {
+				t.Errorf("list: %s has no stats", apiUrl.Id)
+			}
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if strings.Join(got, ",") != "id0,id1,id2,id3,id4" {
+		t.Errorf("list: got %v", got)
+	}
+
+	// hits are only added once for each hour, and go to the campaign they were recorded with too
+	for i := 0; i < 2; i++ {
+		added, err := store.saveHits("20261016-12:id1", "id1", t0, 3, map[string]int64{"A": 2, "B": 1}, map[string]int64{"launch": 2, "teaser": 1})
+		if err != nil || added != (i == 0) {
+			t.Errorf("saveHits %d: got %v, %v", i, added, err)
+		}
+	}
+	stats, err := store.getStats("id1")
+	if err != nil || stats.Total != 3 || stats.Daily["20261016"] != 3 || stats.Variants["A"] != 2 {
+		t.Errorf("stats: got %+v, %v", stats, err)
+	}
+	if stats, err := store.getStats("id2"); err != nil || stats == nil || stats.Total != 0 {
+		t.Errorf("no stats: got %+v, %v", stats, err)
+	}
+	campaign, err := store.getCampaignStats("id1", "launch")
+	if err != nil || campaign == nil || campaign.Total != 2 {
+		t.Errorf("campaign: got %+v, %v", campaign, err)
+	}
+	if campaign, err := store.getCampaignStats("id1", "teaser"); err != nil || campaign == nil || campaign.Total != 1 {
+		t.Errorf("previous campaign: got %+v, %v", campaign, err)
+	}
+	if campaign, err := store.getCampaignStats("id1", "nope"); campaign != nil || err != nil {
+		t.Errorf("no campaign: got %+v, %v", campaign, err)
+	}
+	if campaign, err := store.getCampaignStats("id2", "launch"); campaign != nil || err != nil {
+		t.Errorf("another's campaign: got %+v, %v", campaign, err)
+	}
+
+	// everything else kept about a ShortUrl
+	if ok, err := store.useHit(shortUrl); !ok || err != nil {
+		t.Errorf("useHit: got %v, %v", ok, err)
+	}
+	if hits, err := store.getHits("id1"); hits != 1 || err != nil {
+		t.Errorf("getHits: got %d, %v", hits, err)
+	}
+	if err := store.putMeta("id1", &Meta{Url: shortUrl.Url, Title: "One"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.putHealth("id1", []*Health{{Url: shortUrl.Url, Dead: true, Failures: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if broken, err := store.listBrokenLinks(); err != nil || len(broken) != 1 || broken[0].Id != "id1" {
+		t.Errorf("broken: got %+v, %v", broken, err)
+	}
+	if tags, err := store.listTags(); err != nil || tags["docs"] != 5 || tags["team"] != 1 || len(tags) != 2 {
+		t.Errorf("tags: got %v, %v", tags, err)
+	}
+	if results, err := store.searchShortUrls("", "team"); err != nil || len(results) != 1 || results[0].Id != "id1" {
+		t.Errorf("tagged: got %+v, %v", results, err)
+	}
+
+	// plain ShortUrls are found again by their destination
+	shared := ShortUrl{Url: "https://example.com/shared"}
+	if _, err := store.createShortUrl(noLists, testIds, &shared, "", true); err != nil {
+		t.Fatal(err)
+	}
+	again := ShortUrl{Url: "https://example.com/shared"}
+	if reused, err := store.createShortUrl(noLists, testIds, &again, "", true); !reused || err != nil || again.Id != shared.Id {
+		t.Errorf("shared: got %v, %v, %s, want %s", reused, err, again.Id, shared.Id)
+	}
+
+	// deleting them removes everything kept about them, including from every index and their campaigns
+	for _, id := range []string{"id1", shared.Id} {
+		if err := store.deleteShortUrl(id, now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shortUrl, err := store.getShortUrl("id1"); shortUrl != nil || err != nil {
+		t.Errorf("after delete: got %+v, %v", shortUrl, err)
+	}
+	if stats, err := store.getStats("id1"); err != nil || stats.Total != 0 {
+		t.Errorf("stats after delete: got %+v, %v", stats, err)
+	}
+	if hits, err := store.getHits("id1"); hits != 0 || err != nil {
+		t.Errorf("hits after delete: got %d, %v", hits, err)
+	}
+	if meta, err := store.getMeta("id1"); meta != nil || err != nil {
+		t.Errorf("meta after delete: got %+v, %v", meta, err)
+	}
+	if health, err := store.getHealth("id1"); health != nil || err != nil {
+		t.Errorf("health after delete: got %+v, %v", health, err)
+	}
+	if tags, err := store.listTags(); err != nil || tags["docs"] != 4 || len(tags) != 1 {
+		t.Errorf("tags after delete: got %v, %v", tags, err)
+	}
+	if results, err := store.searchShortUrls("", "team"); err != nil || len(results) != 0 {
+		t.Errorf("tagged after delete: got %+v, %v", results, err)
+	}
+	again = ShortUrl{Url: "https://example.com/shared"}
+	if reused, err := store.createShortUrl(noLists, testIds, &again, "", true); reused || err != nil {
+		t.Errorf("shared after delete: got %v, %v", reused, err)
+	}
+	if campaign, err := store.getCampaignStats("id1", "launch"); campaign != nil || err != nil {
+		t.Errorf("campaign after delete: got %+v, %v", campaign, err)
+	}
+
+	// expired ShortUrls are reaped, and those used up too
+	expired := ShortUrl{Url: "https://example.com/expired", ExpiresAt: &t0}
+	if _, err := store.createShortUrl(noLists, testIds, &expired, "expired", false); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := store.reapExpired(t0.Add(time.Hour)); n != 1 || err != nil {
+		t.Errorf("reapExpired: got %d, %v", n, err)
+	}
+	if tombstone, err := store.getTombstone("expired"); err != nil || tombstone == nil || tombstone.Reason != expiredReason {
+		t.Errorf("expired after reaping: got %+v, %v", tombstone, err)
+	}
+
+	// IDs from a sequence
+	ids := make([]string, 2)
+	for i := range ids {
+		counted := ShortUrl{Url: "https://example.com/counted"}
+		if _, err := store.createShortUrl(noLists, counterIds{}, &counted, "", false); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = counted.Id
+	}
+	if ids[0] == ids[1] {
+		t.Errorf("counter: got %v twice", ids[0])
+	}
+
+	// taken down ids aren't given out again
+	takedown := Takedown{Id: "id0", Reason: "Phishing", Status: 451, Created: t0}
+	if err := store.putTakedown(&takedown); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.getTakedown("id0"); got == nil || got.Status != 451 || err != nil {
+		t.Errorf("takedown: got %+v, %v", got, err)
+	}
+	if takedowns, err := store.listTakedowns(); err != nil || len(takedowns) != 1 {
+		t.Errorf("takedowns: got %+v, %v", takedowns, err)
+	}
+	if err := store.deleteShortUrl("id0", now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "id0", false); err != ErrSlugTaken {
+		t.Errorf("taken down: got %v, want ErrSlugTaken", err)
+	}
+	if err := store.delTakedown("id0"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.getTakedown("id0"); got != nil || err != nil {
+		t.Errorf("takedown after delete: got %+v, %v", got, err)
+	}
+
+	if err := store.putBlock(&Block{}); err != ErrInvalidBlock {
+		t.Errorf("empty block: got %v, want ErrInvalidBlock", err)
+	}
+	for _, pattern := range []string{"Evil.Example", "bad.example/*"} {
+		if err := store.putBlock(&Block{Pattern: pattern, Reason: "Phishing."}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	blocks, err := store.listBlocks()
+	if err != nil || len(blocks) != 2 || blocks[0].Pattern != "bad.example/*" {
+		t.Errorf("blocks: got %+v, %v", blocks, err)
+	}
+	if block, err := store.findBlock("https://www.evil.example/"); block == nil || err != nil {
+		t.Errorf("findBlock: got %+v, %v", block, err)
+	}
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://bad.example/x"}, "", false); err != ErrDestinationBlocked {
+		t.Errorf("blocked: got %v, want ErrDestinationBlocked", err)
+	}
+	if err := store.delBlock("evil.example"); err != nil {
+		t.Fatal(err)
+	}
+	if block, err := store.findBlock("https://evil.example/"); block != nil || err != nil {
+		t.Errorf("findBlock after delete: got %+v, %v", block, err)
+	}
+
+	// nothing is kept from a transaction which fails, nor can a read-only one change anything
+	err = rawStore(store).update(func(tx storeTx) error {
+		if err := putString(tx, urlBucketNameStr, "rolled-back", "{}"); err != nil {
+			return err
+		}
+		if err := tx.del(urlBucketNameStr, "id2"); err != nil {
+			return err
+		}
+		if _, err := tx.nextSequence(urlBucketNameStr); err != nil {
+			return err
+		}
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("failed update: got %v", err)
+	}
+	for id, want := range map[string]bool{"rolled-back": false, "id2": true} {
+		if shortUrl, err := store.getShortUrl(id); (shortUrl != nil) != want || err != nil {
+			t.Errorf("after rollback: got %+v, %v for %s", shortUrl, err, id)
+		}
+	}
+	err = rawStore(store).view(func(tx storeTx) error {
+		return putString(tx, urlBucketNameStr, "read-only", "{}")
+	})
+	if err == nil {
+		t.Errorf("read-only: got no error")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, newTestStore(t))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, newBucketStore(newMemoryStore()))
+}
+
+func TestMemoryStoreCopies(t *testing.T) {
+	store := newMemoryStore()
+	err := store.update(func(tx storeTx) error {
+		return putString(tx, urlBucketNameStr, "abc", "kept")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// changing what get or each returned mustn't change what's kept
+	err = store.view(func(tx storeTx) error {
+		value, err := tx.get(urlBucketNameStr, "abc")
+		if err != nil {
+			return err
+		}
+		copy(value, "XXXX")
+		return each(tx, urlBucketNameStr, "", func(key string, value []byte) error {
+			copy(value, "YYYY")
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.view(func(tx storeTx) error {
+		if got, _ := getString(tx, urlBucketNameStr, "abc"); got != "kept" {
+			t.Errorf("got %q, want kept", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenStore(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		kind, dsn string
+		err       error
+	}{
+		{"", filepath.Join(dir, "pow.db"), nil},
+		{"bolt", filepath.Join(dir, "other.db"), nil},
+		{"memory", "", nil},
+		{"sql", filepath.Join(dir, "pow.sqlite"), nil},
+		{"sql", "", ErrNoStoreDsn},
+		{"redis", "", ErrUnknownStore},
+	}
+	for _, test := range tests {
+		store, err := openStore(test.kind, test.dsn, "")
+		if err != test.err {
+			t.Errorf("%q: got %v, want %v", test.kind, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		// every one gets the legacy takedowns
+		takedown, err := store.getTakedown(legacyTakedowns[0])
+		if takedown == nil || takedown.Status != 410 || err != nil {
+			t.Errorf("%q: got %+v, %v", test.kind, takedown, err)
+		}
+		store.close()
+	}
+}
+
+func TestBoltStoreMigratesTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pow.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(tagBucketNameStr))
+		if err != nil {
+			return err
+		}
+		for tag, ids := range map[string][]string{"docs": {"abc", "def"}, "team": {"abc"}} {
+			tb, err := b.CreateBucket([]byte(tag))
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := tb.Put([]byte(id), []byte{}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.close()
+	if tags, err := newBucketStore(store).listTags(); err != nil || tags["docs"] != 2 || tags["team"] != 1 || len(tags) != 2 {
+		t.Errorf("got %v, %v", tags, err)
+	}
+	err = store.view(func(tx storeTx) error {
+		ids, err := taggedIdsTx(tx, "docs")
+		if strings.Join(ids, ",") != "abc,def" {
+			t.Errorf("docs: got %v", ids)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApiLeavesOutTakenDown(t *testing.T) {
+	store := newBucketStore(newMemoryStore())
+	for _, id := range []string{"abc", "def", "ghi"} {
+		if err := store.putShortUrl(&ShortUrl{Id: id, Url: "https://example.com/" + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.putTakedown(&Takedown{Id: "def", Reason: "Phishing", Status: 451})
+	store.putTakedown(&Takedown{Id: "gone", Reason: "Abuse", Status: 410})
+
+	m := newTestMux()
+	m.Get("/api/v1/urls", apiListUrls(store, "https://pow.example"))
+	m.Get("/api/v1/urls/:id", apiGetUrl(store, "https://pow.example"))
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"abc", http.StatusOK},
+		{"def", 451},
+		{"gone", http.StatusGone},
+		{"nope", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if rec := apiRequest(t, m, "GET", "/api/v1/urls/"+test.id, "", nil); rec.Code != test.status {
+			t.Errorf("GET %s: got %d, want %d", test.id, rec.Code, test.status)
+		}
+	}
+
+	list := ApiUrlList{}
+	apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls", "", &list)
+	ids := []string{}
+	for _, apiUrl := range list.Urls {
+		ids = append(ids, apiUrl.Id)
+	}
+	if strings.Join(ids, ",") != "abc,ghi" {
+		t.Errorf("listed %v, want [abc ghi]", ids)
+	}
+}
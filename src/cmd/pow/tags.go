@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const titleMaxLen = 200
+const tagsMax = 20
+
+var (
+	ErrTitleTooLong = errors.New("Title must be at most 200 characters long")
+	ErrTooManyTags  = errors.New("A Short URL can have at most 20 tags")
+	ErrInvalidTag   = errors.New("Tags must be 1 to 32 letters, numbers, '-' or '_'")
+)
+
+var tagRegExp = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// parseTags splits the tags given in a form, which may be separated by commas and/or spaces.
+func parseTags(str string) []string {
+	return strings.FieldsFunc(str, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+func validateTitle(title string) (string, error) {
+	title = strings.TrimSpace(title)
+	if len(title) > titleMaxLen {
+		return "", ErrTitleTooLong
+	}
+	return title, nil
+}
+
+// validateTags checks the tags and returns them in lowercase, without any duplicates.
+func validateTags(tags []string) ([]string, error) {
+	valid := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !tagRegExp.MatchString(tag) {
+			return nil, ErrInvalidTag
+		}
+		if !containsString(valid, tag) {
+			valid = append(valid, tag)
+		}
+	}
+	if len(valid) > tagsMax {
+		return nil, ErrTooManyTags
+	}
+	if len(valid) == 0 {
+		return nil, nil
+	}
+	return valid, nil
+}
+
+// tagKey is the key in the tag bucket which says this ShortUrl has this tag. Since tags can't contain a ':', the keys
+// of each tag are all together and start with it's tagPrefix.
+func tagKey(tag, id string) string {
+	return tagPrefix(tag) + id
+}
+
+func tagPrefix(tag string) string {
+	return tag + ":"
+}
+
+// reindexTagsTx moves this ShortUrl from the index of each of it's previous tags to that of each of it's current
+// ones.
+func reindexTagsTx(tx storeTx, id string, prev, tags []string) error {
+	for _, tag := range prev {
+		if containsString(tags, tag) {
+			continue
+		}
+		if err := tx.del(tagBucketNameStr, tagKey(tag, id)); err != nil {
+			return err
+		}
+	}
+
+	for _, tag := range tags {
+		if err := tx.put(tagBucketNameStr, tagKey(tag, id), []byte{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// taggedIdsTx returns the id of every ShortUrl with this tag.
+func taggedIdsTx(tx storeTx, tag string) ([]string, error) {
+	ids := make([]string, 0)
+	prefix := tagPrefix(tag)
+	err := each(tx, tagBucketNameStr, prefix, func(k string, v []byte) error {
+		if !strings.HasPrefix(k, prefix) {
+			return errStop
+		}
+		ids = append(ids, strings.TrimPrefix(k, prefix))
+		return nil
+	})
+	return ids, err
+}
+
+// listTags returns every tag in use, along with how many ShortUrls have it.
+func (s *bucketStore) listTags() (map[string]int, error) {
+	tags := make(map[string]int)
+	err := s.view(func(tx storeTx) error {
+		return each(tx, tagBucketNameStr, "", func(k string, v []byte) error {
+			if i := strings.Index(k, ":"); i != -1 {
+				tags[k[:i]]++
+			}
+			return nil
+		})
+	})
+	return tags, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	if got := parseTags(" docs, go\tinternal,,"); strings.Join(got, "|") != "docs|go|internal" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tags, err := validateTags([]string{"Docs", " go ", "docs"})
+	if err != nil || strings.Join(tags, ",") != "docs,go" {
+		t.Errorf("got %v, %v", tags, err)
+	}
+	if tags, err := validateTags([]string{}); tags != nil || err != nil {
+		t.Errorf("none: got %v, %v", tags, err)
+	}
+	if _, err := validateTags([]string{"not a tag"}); err != ErrInvalidTag {
+		t.Errorf("got %v, want ErrInvalidTag", err)
+	}
+	tooMany := make([]string, tagsMax+1)
+	for i := range tooMany {
+		tooMany[i] = strings.Repeat("t", i+1)
+	}
+	if _, err := validateTags(tooMany); err != ErrTooManyTags {
+		t.Errorf("got %v, want ErrTooManyTags", err)
+	}
+
+	if _, err := validateTitle(strings.Repeat("x", titleMaxLen+1)); err != ErrTitleTooLong {
+		t.Errorf("got %v, want ErrTitleTooLong", err)
+	}
+}
+
+func TestTagIndex(t *testing.T) {
+	store := newTestStore(t)
+
+	docs := ShortUrl{Url: "https://docs.example.com/", Title: "Team Handbook", Tags: []string{"docs", "team"}}
+	if _, err := store.createShortUrl(noLists, testIds, &docs, "handbook", false); err != nil {
+		t.Fatal(err)
+	}
+	wiki := ShortUrl{Url: "https://wiki.example.com/", Tags: []string{"docs"}}
+	if _, err := store.createShortUrl(noLists, testIds, &wiki, "wiki", false); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := store.listTags()
+	if err != nil || tags["docs"] != 2 || tags["team"] != 1 {
+		t.Errorf("got %v, %v", tags, err)
+	}
+
+	// retagging moves it between the indexes, and drops empty tags
+	_, err = store.updateShortUrl(noLists, "handbook", func(shortUrl *ShortUrl) error {
+		shortUrl.Tags = []string{"docs", "hr"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err = store.listTags()
+	if err != nil || len(tags) != 2 || tags["hr"] != 1 || tags["docs"] != 2 {
+		t.Errorf("after update: got %v, %v", tags, err)
+	}
+
+	if err := store.deleteShortUrl("wiki", now()); err != nil {
+		t.Fatal(err)
+	}
+	tags, err = store.listTags()
+	if err != nil || tags["docs"] != 1 {
+		t.Errorf("after delete: got %v, %v", tags, err)
+	}
+}
+
+func TestApiSearch(t *testing.T) {
+	store := newTestStore(t)
+	links := []struct {
+		slug     string
+		shortUrl ShortUrl
+	}{
+		{"handbook", ShortUrl{Url: "https://docs.example.com/", Title: "Team Handbook", Tags: []string{"hr"}}},
+		{"wiki", ShortUrl{Url: "https://wiki.example.com/", Tags: []string{"docs"}}},
+		{"secret", ShortUrl{Url: "https://hidden.example.com/", Password: "open sesame"}},
+	}
+	for _, link := range links {
+		if _, err := store.createShortUrl(noLists, testIds, &link.shortUrl, link.slug, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := newTestMux()
+	m.Get("/api/v1/search", apiSearch(store, "https://pow.example"))
+	m.Get("/api/v1/tags", apiListTags(store))
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"q=handbook", "handbook"},
+		{"q=docs", "handbook,wiki"}, // by host and by tag
+		{"q=team", "handbook"},
+		{"q=book", "handbook"}, // only contains it
+		{"tag=hr", "handbook"},
+		{"q=wiki&tag=hr", ""},
+		{"q=hidden", ""}, // the destination of protected links is secret
+	}
+	for _, test := range tests {
+		res := ApiSearch{}
+		rec := apiRequest(t, m, "GET", "/api/v1/search?"+test.query, "", &res)
+		ids := make([]string, len(res.Urls))
+		for i, apiUrl := range res.Urls {
+			ids[i] = apiUrl.Id
+		}
+		if rec.Code != http.StatusOK || strings.Join(ids, ",") != test.want {
+			t.Errorf("search %s: got %d %v, want %s", test.query, rec.Code, ids, test.want)
+		}
+	}
+
+	apiErr := ApiError{}
+	if rec := apiRequest(t, m, "GET", "/api/v1/search", "", &apiErr); rec.Code != http.StatusBadRequest {
+		t.Errorf("no query: got %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	list := ApiTagList{}
+	apiRequest(t, m, "GET", "/api/v1/tags", "", &list)
+	if len(list.Tags) != 2 || list.Tags["docs"] != 1 {
+		t.Errorf("tags: got %+v", list.Tags)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDestinationBlocked = errors.New("This destination has been blocked")
+	ErrInvalidTakedown    = errors.New("Takedown must have an Id and a Status of either 410 or 451")
+	ErrInvalidBlock       = errors.New("Block must have a Pattern")
+)
+
+// legacyTakedowns were removed by hand before takedowns existed, so they are migrated on startup to make sure their
+// Ids are never given out again.
+var legacyTakedowns = []string{"RToXsy", "iyzqGc"}
+
+// wildcardMatch tells you whether str matches this pattern, where `*` matches any run of characters.
+func wildcardMatch(pattern, str string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == str
+	}
+
+	if !strings.HasPrefix(str, parts[0]) {
+		return false
+	}
+	str = str[len(parts[0]):]
+
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(str, part)
+		if i == -1 {
+			return false
+		}
+		str = str[i+len(part):]
+	}
+
+	return strings.HasSuffix(str, parts[len(parts)-1])
+}
+
+// matchesPattern tells you whether this URL matches a Block pattern. See Block for the pattern format.
+func matchesPattern(pattern string, u *url.URL) bool {
+	pattern = strings.ToLower(pattern)
+	host := strings.ToLower(u.Hostname())
+
+	if !strings.ContainsAny(pattern, "*/") {
+		return host == pattern || strings.HasSuffix(host, "."+pattern)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return wildcardMatch(pattern, host+path)
+}
+
+// findBlockTx returns the first Block which matches this destination, or nil if it isn't blocked.
+func findBlockTx(tx storeTx, str string) (*Block, error) {
+	u, err := url.Parse(str)
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Block
+	err = each(tx, blockBucketNameStr, "", func(k string, v []byte) error {
+		block := Block{}
+		if err := json.Unmarshal(v, &block); err != nil {
+			return err
+		}
+		if matchesPattern(block.Pattern, u) {
+			found = &block
+			return errStop
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (s *bucketStore) findBlock(str string) (*Block, error) {
+	var block *Block
+	err := s.view(func(tx storeTx) error {
+		var err error
+		block, err = findBlockTx(tx, str)
+		return err
+	})
+	return block, err
+}
+
+func (s *bucketStore) getTakedown(id string) (*Takedown, error) {
+	var takedown *Takedown
+	err := s.view(func(tx storeTx) error {
+		return getJson(tx, takedownBucketNameStr, id, &takedown)
+	})
+	return takedown, err
+}
+
+func (s *bucketStore) putTakedown(takedown *Takedown) error {
+	if takedown.Id == "" || (takedown.Status != 410 && takedown.Status != 451) {
+		return ErrInvalidTakedown
+	}
+	return s.update(func(tx storeTx) error {
+		return putJson(tx, takedownBucketNameStr, takedown.Id, takedown)
+	})
+}
+
+func (s *bucketStore) delTakedown(id string) error {
+	return s.update(func(tx storeTx) error {
+		return tx.del(takedownBucketNameStr, id)
+	})
+}
+
+func (s *bucketStore) listTakedowns() ([]*Takedown, error) {
+	takedowns := make([]*Takedown, 0)
+	err := s.view(func(tx storeTx) error {
+		return each(tx, takedownBucketNameStr, "", func(k string, v []byte) error {
+			takedown := Takedown{}
+			if err := json.Unmarshal(v, &takedown); err != nil {
+				return err
+			}
+			takedowns = append(takedowns, &takedown)
+			return nil
+		})
+	})
+	return takedowns, err
+}
+
+func (s *bucketStore) putBlock(block *Block) error {
+	if block.Pattern == "" {
+		return ErrInvalidBlock
+	}
+	return s.update(func(tx storeTx) error {
+		return putJson(tx, blockBucketNameStr, strings.ToLower(block.Pattern), block)
+	})
+}
+
+func (s *bucketStore) delBlock(pattern string) error {
+	return s.update(func(tx storeTx) error {
+		return tx.del(blockBucketNameStr, strings.ToLower(pattern))
+	})
+}
+
+func (s *bucketStore) listBlocks() ([]*Block, error) {
+	blocks := make([]*Block, 0)
+	err := s.view(func(tx storeTx) error {
+		return each(tx, blockBucketNameStr, "", func(k string, v []byte) error {
+			block := Block{}
+			if err := json.Unmarshal(v, &block); err != nil {
+				return err
+			}
+			blocks = append(blocks, &block)
+			return nil
+		})
+	})
+	return blocks, err
+}
+
+// migrateLegacyTakedowns turns each of the legacyTakedowns into a real Takedown, removing the ShortUrl if it still
+// exists.
+func migrateLegacyTakedowns(tx storeTx, t time.Time) error {
+	for _, id := range legacyTakedowns {
+		v, err := tx.get(takedownBucketNameStr, id)
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			continue
+		}
+
+		takedown := Takedown{
+			Id:      id,
+			Reason:  "This link was removed for abuse.",
+			Status:  410,
+			Created: t,
+		}
+		if err := putJson(tx, takedownBucketNameStr, id, takedown); err != nil {
+			return err
+		}
+		if err := tx.del(urlBucketNameStr, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMatchesPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		url     string
+		want    bool
+	}{
+		{"example.com", "https://example.com/", true},
+		{"example.com", "https://www.EXAMPLE.com/path", true},
+		{"example.com", "https://notexample.com/", false},
+		{"example.com/bad/*", "https://example.com/bad/thing", true},
+		{"example.com/bad/*", "https://example.com/good/thing", false},
+		{"*.example.com/*", "https://cdn.example.com/x", true},
+		{"*.example.com/*", "https://example.com/x", false},
+		{"example.com/", "https://example.com", true},
+		{"*/phish*.html", "http://anywhere.net/a/phishing.html", true},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchesPattern(test.pattern, u); got != test.want {
+			t.Errorf("matchesPattern(%q, %q) = %v, want %v", test.pattern, test.url, got, test.want)
+		}
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.putBlock(&Block{}); err != ErrInvalidBlock {
+		t.Errorf("empty pattern: got %v, want ErrInvalidBlock", err)
+	}
+	if err := store.putBlock(&Block{Pattern: "Bad.Example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://www.bad.example/"}, "", false)
+	if err != ErrDestinationBlocked {
+		t.Errorf("create: got %v, want ErrDestinationBlocked", err)
+	}
+
+	shortUrl := ShortUrl{Url: "https://good.example/"}
+	if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "", false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.updateShortUrl(noLists, shortUrl.Id, func(shortUrl *ShortUrl) error {
+		return changeDestination(shortUrl, "https://bad.example/", now())
+	})
+	if err != ErrDestinationBlocked {
+		t.Errorf("update: got %v, want ErrDestinationBlocked", err)
+	}
+
+	if err := store.delBlock("bad.example"); err != nil {
+		t.Fatal(err)
+	}
+	if block, err := store.findBlock("https://bad.example/"); block != nil || err != nil {
+		t.Errorf("after delete: got %+v (%v)", block, err)
+	}
+}
+
+func TestTakedowns(t *testing.T) {
+	store := newTestStore(t)
+
+	shortUrl := ShortUrl{Url: "https://example.com/"}
+	if _, err := store.createShortUrl(noLists, testIds, &shortUrl, "phish", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.putTakedown(&Takedown{Id: "phish", Status: 404}); err != ErrInvalidTakedown {
+		t.Errorf("status 404: got %v, want ErrInvalidTakedown", err)
+	}
+	if err := store.putTakedown(&Takedown{Id: "phish", Reason: "Phishing.", Status: 451}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.deleteShortUrl("phish", now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the Id of a takedown is never given out again
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "phish", false); err != ErrSlugTaken {
+		t.Errorf("reusing the Id: got %v, want ErrSlugTaken", err)
+	}
+
+	// and the API says why, whether listing or getting
+	if _, err := store.createShortUrl(noLists, testIds, &ShortUrl{Url: "https://example.com/"}, "fine", false); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestApiFor(store)
+
+	apiErr := ApiError{}
+	rec := apiRequest(t, m, "GET", "/api/v1/urls/phish", "", &apiErr)
+	if rec.Code != 451 || apiErr.Code != "taken-down" || apiErr.Message != "Phishing." {
+		t.Errorf("GET phish: got %d %+v", rec.Code, apiErr)
+	}
+
+	list := ApiUrlList{}
+	apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls", "", &list)
+	if len(list.Urls) != 1 || list.Urls[0].Id != "fine" {
+		t.Errorf("list: got %+v", list.Urls)
+	}
+
+	if err := store.putTakedown(&Takedown{Id: "fine", Reason: "Abuse.", Status: 410}); err != nil {
+		t.Fatal(err)
+	}
+	list = ApiUrlList{}
+	apiRequestAs(t, m, testAdminToken, "GET", "/api/v1/urls", "", &list)
+	if len(list.Urls) != 0 {
+		t.Errorf("list after takedown: got %+v", list.Urls)
+	}
+}
+
+func TestMigrateLegacyTakedowns(t *testing.T) {
+	store := newTestStore(t)
+	t0 := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if err := rawStore(store).update(func(tx storeTx) error { return migrateLegacyTakedowns(tx, t0) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	takedowns, err := store.listTakedowns()
+	if err != nil || len(takedowns) != len(legacyTakedowns) {
+		t.Fatalf("got %d takedowns (%v), want %d", len(takedowns), err, len(legacyTakedowns))
+	}
+	if takedowns[0].Status != http.StatusGone {
+		t.Errorf("got %+v, want status 410", takedowns[0])
+	}
+}
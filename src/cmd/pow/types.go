@@ -3,15 +3,152 @@ package main
 import "time"
 
 type ShortUrl struct {
+	Id               string
+	Url              string
+	Created          time.Time
+	Updated          time.Time
+	ExpiresAt        *time.Time    `json:",omitempty"`
+	MaxHits          int64         `json:",omitempty"`
+	Password         string        `json:",omitempty"` // salted hash, see hashPassword()
+	Owner            string        `json:",omitempty"` // hash of the management token, see newOwnerToken()
+	Disabled         bool          `json:",omitempty"`
+	History          []Destination `json:",omitempty"`
+	Title            string        `json:",omitempty"`
+	Notes            string        `json:",omitempty"`
+	Tags             []string      `json:",omitempty"` // lowercase, and indexed in the tag bucket
+	RedirectStatus   int           `json:",omitempty"` // 301, 302, 307 or 308, or 0 for the instance default
+	QueryPassthrough string        `json:",omitempty"` // "merge" or "override", see mergeQuery()
+	PathPassthrough  bool          `json:",omitempty"` // append any path after the id to the Url
+	Campaign         *Campaign     `json:",omitempty"`
+	Rules            []Rule        `json:",omitempty"` // the first matching rule decides the destination, see rules.go
+	Variants         []Variant     `json:",omitempty"` // used instead of Url when no rule matches
+	Sticky           bool          `json:",omitempty"` // visitors keep getting the same variant
+	DeepLink         *DeepLink     `json:",omitempty"` // opens an app on phones, see deeplink.go
+	Template         string        `json:",omitempty"` // used instead when there's a path after the id, see golinks.go
+}
+
+// Destination is a previous Url of a ShortUrl, and when it was changed.
+type Destination struct {
+	Url   string
+	Until time.Time
+}
+
+type Stats struct {
+	Total    int64
+	Daily    map[string]int64
+	Hourly   map[string]int64
+	DOTWly   map[string]int64
+	Variants map[string]int64 `json:",omitempty"` // by variant name
+}
+
+// ApiNewUrl is the body accepted by the API when creating a new ShortUrl.
+type ApiNewUrl struct {
+	Url              string
+	Slug             string
+	ExpiresAt        *time.Time
+	MaxHits          int64
+	Password         string
+	Share            bool // share any existing ShortUrl for this Url, and if there isn't one create a new one nobody owns
+	Title            string
+	Notes            string
+	Tags             []string
+	RedirectStatus   int
+	QueryPassthrough string
+	PathPassthrough  bool
+	Campaign         *Campaign
+	Rules            []Rule
+	Variants         []Variant
+	Sticky           bool
+	DeepLink         *DeepLink
+	Template         string
+}
+
+// ApiUpdateUrl is the body accepted by the API when changing a ShortUrl. Only the fields given are changed.
+type ApiUpdateUrl struct {
+	Url              *string
+	Disabled         *bool
+	Title            *string
+	Notes            *string
+	Tags             *[]string // replaces all tags, so an empty list removes them
+	RedirectStatus   *int
+	QueryPassthrough *string
+	PathPassthrough  *bool
+	Campaign         *Campaign // an empty Campaign removes it
+	Rules            *[]Rule   // replaces all rules, so an empty list removes them
+	Variants         *[]Variant
+	Sticky           *bool
+	DeepLink         *DeepLink // an empty DeepLink removes it
+	Template         *string
+}
+
+// ApiUrl is what the API returns for each ShortUrl, which includes the full short link and any stats. The Token and
+// ManageLink are only ever returned once, when the ShortUrl is created.
+type ApiUrl struct {
+	ShortUrl
+	Link       string
+	Protected  bool
+	Token      string `json:",omitempty"`
+	ManageLink string `json:",omitempty"`
+	Stats      *Stats
+}
+
+// ApiUrlList is a page of ShortUrls. Pass Next as the `cursor` to get the following page.
+type ApiUrlList struct {
+	Urls []*ApiUrl
+	Next string
+}
+
+// ApiSearch is the ShortUrls found for a search, best matches first.
+type ApiSearch struct {
+	Query string
+	Tag   string
+	Urls  []*ApiUrl
+}
+
+// ApiTagList is every tag in use, and how many ShortUrls have it.
+type ApiTagList struct {
+	Tags map[string]int
+}
+
+// ApiError is the body returned by the API whenever something goes wrong.
+type ApiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+// Takedown is a ShortUrl which has been taken down by an admin, and why. Status is either 410 or 451.
+type Takedown struct {
 	Id      string
-	Url     string
+	Reason  string
+	Status  int
 	Created time.Time
-	Updated time.Time
 }
 
-type Stats struct {
-	Total  int64
-	Daily  map[string]int64
-	Hourly map[string]int64
-	DOTWly map[string]int64
+// Tombstone is what's left behind of a ShortUrl which expired, was used up or was deleted by it's owner, so that it's Id
+// is never given out again and visitors are still told why it's gone.
+type Tombstone struct {
+	Id      string
+	Reason  string
+	Created time.Time
+}
+
+// Block is a destination which can no longer be shortened or redirected to. The Pattern is either a domain (which
+// also matches all subdomains) or a pattern containing `*` and/or `/` which is matched against "host/path".
+type Block struct {
+	Pattern string
+	Reason  string
+	Created time.Time
+}
+
+// BulkResult is the outcome of each row given to the bulk endpoint. Either Link (and Token, if this is a new
+// ShortUrl) or Error is set.
+type BulkResult struct {
+	Row   int
+	Url   string
+	Slug  string `json:",omitempty"`
+	Notes string `json:",omitempty"`
+	Link  string `json:",omitempty"`
+	Token string `json:",omitempty"`
+	Error string `json:",omitempty"`
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const variantsMax = 10
+const variantCookieTtl = 30 * 24 * time.Hour
+
+var (
+	ErrTooManyVariants  = errors.New("A Short URL can have at most 10 variants")
+	ErrVariantName      = errors.New("Variant names must be 1 to 32 letters, numbers, '-' or '_'")
+	ErrVariantDuplicate = errors.New("Variant names must be unique")
+	ErrVariantWeight    = errors.New("Variant weights must be between 0 and 1000")
+	ErrVariantNoWeight  = errors.New("At least one variant must have a weight")
+)
+
+var variantNameRegExp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Variant is one of the destinations a ShortUrl splits it's traffic between, in proportion to it's Weight.
+type Variant struct {
+	Name   string
+	Url    string
+	Weight int
+}
+
+// validateVariants checks the variants and returns them with their Urls normalised. Variants without a name are named
+// after their position, i.e. "A", "B", "C" and so on.
+func validateVariants(variants []Variant) ([]Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) > variantsMax {
+		return nil, ErrTooManyVariants
+	}
+
+	valid := make([]Variant, 0, len(variants))
+	names := make(map[string]bool)
+	total := 0
+	for i, variant := range variants {
+		if variant.Name == "" {
+			variant.Name = string(rune('A' + i))
+		}
+		if !variantNameRegExp.MatchString(variant.Name) {
+			return nil, fmt.Errorf("Variant %d: %s", i+1, ErrVariantName)
+		}
+		if names[variant.Name] {
+			return nil, fmt.Errorf("Variant %d: %s", i+1, ErrVariantDuplicate)
+		}
+		names[variant.Name] = true
+
+		u, err := validateUrl(variant.Url)
+		if err != nil {
+			return nil, fmt.Errorf("Variant %d: %s", i+1, err)
+		}
+		variant.Url = u.String()
+
+		if variant.Weight < 0 || variant.Weight > 1000 {
+			return nil, fmt.Errorf("Variant %d: %s", i+1, ErrVariantWeight)
+		}
+		total += variant.Weight
+
+		valid = append(valid, variant)
+	}
+	if total == 0 {
+		return nil, ErrVariantNoWeight
+	}
+
+	return valid, nil
+}
+
+func variantCookieName(shortUrl *ShortUrl) string {
+	return "pow-variant-" + shortUrl.Id
+}
+
+// findVariant returns the variant with this name, or nil if there isn't one.
+func (s *ShortUrl) findVariant(name string) *Variant {
+	for i := range s.Variants {
+		if s.Variants[i].Name == name {
+			return &s.Variants[i]
+		}
+	}
+	return nil
+}
+
+// chooseVariant picks one of this ShortUrl's variants at random according to their weights. Sticky ShortUrls give the
+// visitor the same variant as last time, as long as it still exists and has a weight.
+func chooseVariant(r *http.Request, shortUrl *ShortUrl) *Variant {
+	if shortUrl.Sticky {
+		if cookie, err := r.Cookie(variantCookieName(shortUrl)); err == nil {
+			if variant := shortUrl.findVariant(cookie.Value); variant != nil && variant.Weight > 0 {
+				return variant
+			}
+		}
+	}
+
+	total := 0
+	for _, variant := range shortUrl.Variants {
+		total += variant.Weight
+	}
+
+	n := rand.Intn(total)
+	for i := range shortUrl.Variants {
+		n -= shortUrl.Variants[i].Weight
+		if n < 0 {
+			return &shortUrl.Variants[i]
+		}
+	}
+
+	// can't get here since the weights add up to the total
+	return &shortUrl.Variants[len(shortUrl.Variants)-1]
+}
+
+// stickToVariant remembers which variant this visitor was given, so they get it again next time.
+func stickToVariant(w http.ResponseWriter, shortUrl *ShortUrl, variant *Variant, t time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookieName(shortUrl),
+		Value:    variant.Name,
+		Path:     "/" + shortUrl.Id,
+		Expires:  t.Add(variantCookieTtl),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// VariantStats is a variant along with it's share of the traffic and hits, for the preview page.
+type VariantStats struct {
+	Variant
+	Percent int
+	Hits    int64
+}
+
+// variantStats returns each of the variants of this ShortUrl with their stats.
+func variantStats(shortUrl *ShortUrl, stats *Stats) []VariantStats {
+	total := 0
+	for _, variant := range shortUrl.Variants {
+		total += variant.Weight
+	}
+
+	all := make([]VariantStats, 0, len(shortUrl.Variants))
+	for _, variant := range shortUrl.Variants {
+		vs := VariantStats{Variant: variant}
+		if total > 0 {
+			vs.Percent = variant.Weight * 100 / total
+		}
+		if stats != nil {
+			vs.Hits = stats.Variants[variant.Name]
+		}
+		all = append(all, vs)
+	}
+	return all
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateVariants(t *testing.T) {
+	tests := []struct {
+		variants []Variant
+		err      error
+	}{
+		{[]Variant{{Name: "bad name", Url: "https://example.com/a", Weight: 1}}, ErrVariantName},
+		{[]Variant{{Name: "a", Url: "https://example.com/a", Weight: 1}, {Name: "a", Url: "https://example.com/b", Weight: 1}}, ErrVariantDuplicate},
+		{[]Variant{{Url: "https://example.com/a", Weight: 1001}}, ErrVariantWeight},
+		{[]Variant{{Url: "https://example.com/a"}, {Url: "https://example.com/b"}}, ErrVariantNoWeight},
+		{make([]Variant, variantsMax+1), ErrTooManyVariants},
+	}
+	for _, test := range tests {
+		_, err := validateVariants(test.variants)
+		if err == nil || !strings.HasSuffix(err.Error(), test.err.Error()) {
+			t.Errorf("%+v: got %v, want %v", test.variants, err, test.err)
+		}
+	}
+
+	variants, err := validateVariants([]Variant{{Url: "https://example.com/a", Weight: 1}, {Name: "blue", Url: "https://example.com/b"}, {Url: "https://example.com/c", Weight: 2}})
+	if err != nil || variants[0].Name != "A" || variants[1].Name != "blue" || variants[2].Name != "C" {
+		t.Errorf("names: got %+v, %v", variants, err)
+	}
+}
+
+func TestChooseVariant(t *testing.T) {
+	shortUrl := ShortUrl{
+		Id: "abc",
+		Variants: []Variant{
+			{Name: "A", Url: "https://example.com/a", Weight: 3},
+			{Name: "B", Url: "https://example.com/b", Weight: 1},
+			{Name: "C", Url: "https://example.com/c", Weight: 0},
+		},
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		counts[chooseVariant(httptest.NewRequest("GET", "/abc", nil), &shortUrl).Name]++
+	}
+	if counts["C"] != 0 {
+		t.Errorf("variant with no weight chosen %d times", counts["C"])
+	}
+	if counts["A"] < 2700 || counts["A"] > 3300 {
+		t.Errorf("got %v, want about 3000 A and 1000 B", counts)
+	}
+
+	// sticky visitors get their variant back, as long as it still has a weight
+	shortUrl.Sticky = true
+	for _, test := range []struct{ cookie, want string }{{"B", "B"}, {"C", ""}, {"gone", ""}} {
+		r := httptest.NewRequest("GET", "/abc", nil)
+		r.AddCookie(&http.Cookie{Name: variantCookieName(&shortUrl), Value: test.cookie})
+		got := chooseVariant(r, &shortUrl).Name
+		if test.want != "" && got != test.want || test.want == "" && got == "C" {
+			t.Errorf("cookie %s: got %s", test.cookie, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	stickToVariant(rec, &shortUrl, &shortUrl.Variants[1], now())
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "B" || cookies[0].Path != "/abc" {
+		t.Errorf("got cookies %+v", cookies)
+	}
+}
+
+func TestVariantStats(t *testing.T) {
+	shortUrl := ShortUrl{Variants: []Variant{{Name: "A", Weight: 1}, {Name: "B", Weight: 3}}}
+	all := variantStats(&shortUrl, &Stats{Variants: map[string]int64{"A": 5, "B": 7}})
+	if len(all) != 2 || all[0].Percent != 25 || all[1].Percent != 75 || all[0].Hits != 5 || all[1].Hits != 7 {
+		t.Errorf("got %+v", all)
+	}
+}
@@ -0,0 +1,140 @@
+package qr
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// the goldens in testdata are module matrices (without the margin) from an independent encoder, where '#' is dark
+var goldens = []struct {
+	file    string
+	data    string
+	level   Level
+	version int
+}{
+	{"hello-L.txt", "hello", L, 1},
+	{"pow-Q.txt", "https://pow.example/abc", Q, 3},
+	{"a100-Q.txt", strings.Repeat("a", 100), Q, 8},                         // two sizes of block
+	{"example20-L.txt", strings.Repeat("https://example.com/", 20), L, 13}, // version information and 16 bit count
+}
+
+// modules draws this QR Code the same way as the goldens.
+func modules(c *Code) string {
+	buf := &bytes.Buffer{}
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Black(x, y) {
+				buf.WriteByte('#')
+			} else {
+				buf.WriteByte('.')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func TestEncodeGolden(t *testing.T) {
+	for _, golden := range goldens {
+		want, err := os.ReadFile(filepath.Join("testdata", golden.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := Encode([]byte(golden.data), golden.level)
+		if err != nil {
+			t.Fatalf("%s: %s", golden.file, err)
+		}
+		if c.Version != golden.version || c.Size != golden.version*4+17 {
+			t.Errorf("%s: got version %d and size %d, want version %d", golden.file, c.Version, c.Size, golden.version)
+		}
+		if got := modules(c); got != string(want) {
+			t.Errorf("%s: modules differ, got\n%s", golden.file, got)
+		}
+	}
+}
+
+func TestEncodeCapacity(t *testing.T) {
+	tests := []struct {
+		level   Level
+		version int
+		max     int // bytes which fit into this version, one more needs the next version
+	}{
+		{L, 1, 17},
+		{M, 1, 14},
+		{Q, 1, 11},
+		{H, 1, 7},
+		{L, 9, 230},
+		{L, 10, 271}, // the first version with a 16 bit count
+		{L, 40, 2953},
+		{M, 40, 2331},
+		{Q, 40, 1663},
+		{H, 40, 1273},
+	}
+	for _, test := range tests {
+		c, err := Encode(bytes.Repeat([]byte("a"), test.max), test.level)
+		if err != nil || c.Version != test.version {
+			t.Errorf("%d bytes at level %d: got %v (%v), want version %d", test.max, test.level, c, err, test.version)
+			continue
+		}
+
+		c, err = Encode(bytes.Repeat([]byte("a"), test.max+1), test.level)
+		if test.version == 40 {
+			if err != ErrTooLong {
+				t.Errorf("%d bytes at level %d: got %v, want ErrTooLong", test.max+1, test.level, err)
+			}
+			continue
+		}
+		if err != nil || c.Version != test.version+1 {
+			t.Errorf("%d bytes at level %d: got %v (%v), want version %d", test.max+1, test.level, c, err, test.version+1)
+		}
+	}
+}
+
+func TestEncodeInvalidLevel(t *testing.T) {
+	if _, err := Encode([]byte("hello"), Level(4)); err != ErrInvalidLevel {
+		t.Errorf("got %v, want ErrInvalidLevel", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for str, want := range map[string]Level{"L": L, "m": M, "Q": Q, "h": H} {
+		if got, err := ParseLevel(str); got != want || err != nil {
+			t.Errorf("ParseLevel(%q) = %d, %v, want %d", str, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("X"); err != ErrInvalidLevel {
+		t.Errorf("ParseLevel(\"X\"): got %v, want ErrInvalidLevel", err)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	c, err := Encode([]byte("hello"), L)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.PNG(2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 21 modules plus a margin of 4 either side, at 2 pixels each
+	if size := img.Bounds().Dx(); size != 58 || img.Bounds().Dy() != 58 {
+		t.Errorf("got %dx%d, want 58x58", size, img.Bounds().Dy())
+	}
+	if r, _, _, _ := img.At(8, 8).RGBA(); r != 0 {
+		t.Error("top left of the finder is not dark")
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r == 0 {
+		t.Error("margin is not light")
+	}
+}
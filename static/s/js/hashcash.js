@@ -0,0 +1,77 @@
+(function() {
+
+  // counts the zero bits at the start of this hash
+  function leadingZeroBits(bytes) {
+    var n = 0
+    for (var i = 0; i < bytes.length; i++) {
+      if (bytes[i] === 0) {
+        n += 8
+        continue
+      }
+      for (var mask = 0x80; (bytes[i] & mask) === 0; mask >>= 1) {
+        n++
+      }
+      break
+    }
+    return n
+  }
+
+  // how many nonces are tried at once before letting the page respond again
+  var chunk = 1000
+
+  // how many times more attempts than expected to make before giving up, which is very unlikely to be needed
+  var patience = 32
+
+  // hashes a chunk of nonces at a time starting from this one, yielding to the page between each chunk, until one
+  // gives a SHA-256 of "<challenge>:<nonce>" starting with at least this many zero bits
+  function solve(challenge, bits, start) {
+    var maxAttempts = Math.pow(2, bits) * patience
+    var hashes = []
+    for (var nonce = start; nonce < start + chunk; nonce++) {
+      hashes.push(crypto.subtle.digest('SHA-256', new TextEncoder().encode(challenge + ':' + nonce)))
+    }
+    return Promise.all(hashes).then(function(hashes) {
+      for (var i = 0; i < hashes.length; i++) {
+        if (leadingZeroBits(new Uint8Array(hashes[i])) >= bits) {
+          return String(start + i)
+        }
+      }
+      if (start + chunk >= maxAttempts) {
+        throw new Error('No proof of work found')
+      }
+      return new Promise(function(resolve) {
+        setTimeout(resolve, 0)
+      }).then(function() {
+        return solve(challenge, bits, start + chunk)
+      })
+    })
+  }
+
+  // the form this script was included in
+  var form = document.currentScript.closest('form')
+  var challenge = form.querySelector('input[name=challenge]')
+  var nonce = form.querySelector('input[name=nonce]')
+  var submit = form.querySelector('input[type=submit]')
+
+  form.addEventListener('submit', function(ev) {
+    if (nonce.value !== '') {
+      return
+    }
+    ev.preventDefault()
+
+    submit.disabled = true
+    submit.dataset.value = submit.value
+    submit.value = 'Working...'
+    solve(challenge.value, parseInt(challenge.dataset.bits, 10), 0).then(function(solution) {
+      nonce.value = solution
+      submit.disabled = false
+      submit.value = submit.dataset.value
+      form.submit()
+    }, function() {
+      // the challenge will have expired by now anyway, so a fresh page gets a new one
+      submit.disabled = false
+      submit.value = 'Reload and try again'
+    })
+  })
+
+}())